@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -23,7 +24,7 @@ type abortJobResponse struct {
 }
 
 func abortJobPortal(jobId string) {
-	err := execAbortJob(jobId)
+	err := execAbortJob(commandContext(), jobId, false)
 	if err == UnauthorizedError {
 		fmt.Fprintf(os.Stderr, "unauthorized error returned. Check your access token and key.")
 		os.Exit(1)
@@ -53,13 +54,13 @@ func buildAbortJobURL(jobId string) (string, error) {
 	return u.String(), nil
 }
 
-func buildAbortJobRequest(botId string) (*http.Request, error) {
+func buildAbortJobRequest(ctx context.Context, botId string) (*http.Request, error) {
 	url, err := buildAbortJobURL(botId)
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequest("DELETE", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "DELETE", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -101,16 +102,36 @@ func processAbortJobResponse(resp *http.Response) error {
 		return JobAlreadyDoneError
 	default:
 		// error
-		return fmt.Errorf("response code '%d' returned.", ret.Code)
+		return &responseCodeError{Code: ret.Code}
 	}
 
 	return nil
 }
 
-func execAbortJob(jobId string) error {
+// execAbortJob aborts the job and records it in the audit log.
+// confirmed skips the confirmation of the protected profile when the
+// user has agreed to the abort already.
+// The bot of the job is looked up for the record.
+func execAbortJob(ctx context.Context, jobId string, confirmed bool) error {
+	botId := ""
+	if job, err := execShowJob(ctx, jobId); err == nil {
+		botId = job.BotId
+	}
+
+	if err := guardConfirmedMutation(ctx, AuditOperationAbort, botId, jobId, confirmed); err != nil {
+		recordAudit(AuditOperationAbort, botId, jobId, nil, errorCode(err), err)
+		return err
+	}
+
+	err := requestAbortJob(ctx, jobId)
+	recordAudit(AuditOperationAbort, botId, jobId, nil, errorCode(err), err)
+	return err
+}
+
+func requestAbortJob(ctx context.Context, jobId string) error {
 	client := http.DefaultClient
 
-	req, err := buildAbortJobRequest(jobId)
+	req, err := buildAbortJobRequest(ctx, jobId)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	LockFileSuffix = ".lock"
+
+	DefaultLockTimeout = 10 * time.Second
+	StaleLockAge       = 2 * time.Minute
+)
+
+var (
+	LockTimeoutError = errors.New("lock timeout")
+
+	heldLocksMutex sync.Mutex
+	heldLocks      = make(map[string]bool)
+)
+
+// writeFileAtomic writes data to a temporary file in the same directory
+// and renames it to path, so that readers never see a partial file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	name := tmp.Name()
+	defer os.Remove(name)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(name, perm); err != nil {
+		return err
+	}
+	return os.Rename(name, path)
+}
+
+type fileLock struct {
+	path string
+	stop chan struct{}
+}
+
+// lockFile creates path+".lock" exclusively. The lock is taken over
+// when it is older than StaleLockAge and its owner is not running.
+func lockFile(path string, timeout time.Duration) (*fileLock, error) {
+	lockPath := path + LockFileSuffix
+	if err := os.MkdirAll(filepath.Dir(lockPath), 0700); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		f, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			f.WriteString(strconv.Itoa(os.Getpid()))
+			f.Close()
+			heldLocksMutex.Lock()
+			heldLocks[lockPath] = true
+			heldLocksMutex.Unlock()
+			return &fileLock{path: lockPath}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		if fi, err := os.Stat(lockPath); err == nil && isStaleLock(lockPath, fi) {
+			takeOverStaleLock(lockPath, fi)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%v. %s is held by another cbot-cli. Remove it if no cbot-cli is running.", LockTimeoutError, lockPath)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// isStaleLock reports whether the owner of the lock has gone. Old locks
+// of running processes are kept.
+func isStaleLock(lockPath string, fi os.FileInfo) bool {
+	if time.Since(fi.ModTime()) <= StaleLockAge {
+		return false
+	}
+	b, err := ioutil.ReadFile(lockPath)
+	if err != nil {
+		return false
+	}
+	pid, err := strconv.Atoi(string(b))
+	if err != nil || pid <= 0 || pid == os.Getpid() {
+		// broken lock file
+		return true
+	}
+	return !processAlive(pid)
+}
+
+// takeOverStaleLock moves the stale lock aside before removing it, so
+// that a lock newly created by another cbot-cli is never removed.
+func takeOverStaleLock(lockPath string, stale os.FileInfo) {
+	aside := fmt.Sprintf("%s.%d.%d", lockPath, os.Getpid(), time.Now().UnixNano())
+	if err := os.Rename(lockPath, aside); err != nil {
+		// another cbot-cli has taken it over
+		return
+	}
+	if fi, err := os.Stat(aside); err == nil && !os.SameFile(fi, stale) {
+		// the lock was replaced after the check. put it back.
+		if err := os.Link(aside, lockPath); err != nil {
+			return
+		}
+	}
+	os.Remove(aside)
+}
+
+func (l *fileLock) Unlock() error {
+	if l.stop != nil {
+		close(l.stop)
+		l.stop = nil
+	}
+	heldLocksMutex.Lock()
+	delete(heldLocks, l.path)
+	heldLocksMutex.Unlock()
+	return os.Remove(l.path)
+}
+
+// releaseOnInterrupt releases the lock and exits on Ctrl-C until it is
+// unlocked, so that an interrupted command does not leave the lock.
+func (l *fileLock) releaseOnInterrupt() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	l.stop = make(chan struct{})
+	go func(stop chan struct{}) {
+		defer signal.Stop(sig)
+		select {
+		case <-sig:
+			releaseLocks()
+			os.Exit(InterruptExitCode)
+		case <-stop:
+		}
+	}(l.stop)
+}
+
+// releaseLocks removes the locks held by the process before it exits.
+func releaseLocks() {
+	heldLocksMutex.Lock()
+	defer heldLocksMutex.Unlock()
+	for path := range heldLocks {
+		os.Remove(path)
+	}
+	heldLocks = make(map[string]bool)
+}
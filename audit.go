@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AuditFileName      = "audit.jsonl"
+	AuditSyslogEnvName = "CBOT_AUDIT_SYSLOG"
+
+	AuditOperationRun   = "run"
+	AuditOperationAbort = "abort"
+
+	AuditResultOK = "ok"
+)
+
+var (
+	// values of these options are masked in the command
+	secretCommandOptions = map[string]bool{"i": true, "p": true}
+
+	auditMutex sync.Mutex
+)
+
+// auditRecord is a mutating operation requested from this CLI.
+type auditRecord struct {
+	Time      time.Time         `json:"time"`
+	User      string            `json:"user"`
+	Host      string            `json:"host"`
+	Profile   string            `json:"profile"`
+	Command   string            `json:"command"`
+	Operation string            `json:"operation"`
+	BotId     string            `json:"bot_id,omitempty"`
+	JobId     string            `json:"job_id,omitempty"`
+	Input     map[string]string `json:"input,omitempty"`
+	Result    string            `json:"result"`
+	Code      int               `json:"code"`
+}
+
+type auditFilter struct {
+	BotId     string
+	Operation string
+	User      string
+	Profile   string
+	Since     time.Time
+	Until     time.Time
+	Limit     int
+}
+
+func getAuditPath() string {
+	return filepath.Join(getConfigDir(), AuditFileName)
+}
+
+func currentUserName() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	for _, env := range []string{"USER", "USERNAME"} {
+		if v := os.Getenv(env); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// auditCommandLine returns the command line with the inputs masked.
+func auditCommandLine() string {
+	args := make([]string, len(os.Args))
+	copy(args, os.Args)
+	args[0] = filepath.Base(args[0])
+	for i := 1; i < len(args); i++ {
+		if !strings.HasPrefix(args[i], "-") {
+			continue
+		}
+		// -i value, --i value, -i=value and --i=value are the same
+		kv := strings.SplitN(args[i], "=", 2)
+		if !secretCommandOptions[strings.TrimLeft(kv[0], "-")] {
+			continue
+		}
+		if len(kv) == 2 {
+			args[i] = kv[0] + "=" + MaskedValue
+		} else if i+1 < len(args) {
+			args[i+1] = MaskedValue
+			i++
+		}
+	}
+	return strings.Join(args, " ")
+}
+
+// errorCode returns the response code the error stands for.
+func errorCode(err error) int {
+	switch err {
+	case nil:
+		return 200
+	case UnauthorizedError:
+		return 401
+	case ForbiddenError:
+		return 403
+	case BotNotFoundError, JobNotFoundError:
+		return 404
+	case JobAlreadyDoneError:
+		return 410
+	case TooManyExecuteRequestError:
+		return 429
+	}
+	var codeErr *responseCodeError
+	if errors.As(err, &codeErr) {
+		return codeErr.Code
+	}
+	return 0
+}
+
+// recordAudit appends the operation to the audit log and forwards it to
+// syslog when CBOT_AUDIT_SYSLOG is set. Failures are reported but never
+// stop the operation.
+func recordAudit(operation string, botId string, jobId string, input map[string]string, code int, opErr error) {
+	host, _ := os.Hostname()
+	rec := auditRecord{
+		Time:      time.Now(),
+		User:      currentUserName(),
+		Host:      host,
+		Profile:   currentProfileName(),
+		Command:   auditCommandLine(),
+		Operation: operation,
+		BotId:     botId,
+		JobId:     jobId,
+		Input:     maskInput(input),
+		Result:    AuditResultOK,
+		Code:      code,
+	}
+	if opErr != nil {
+		rec.Result = opErr.Error()
+	}
+
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	if err := appendAudit(b); err != nil {
+		fmt.Fprintf(os.Stderr, "audit record failed. %v\n", err)
+	}
+
+	if tag := os.Getenv(AuditSyslogEnvName); tag != "" && tag != "0" && tag != "false" {
+		if tag == "1" || tag == "true" {
+			tag = "cbot-cli"
+		}
+		if err := writeSyslog(tag, string(b)); err != nil {
+			fmt.Fprintf(os.Stderr, "audit syslog failed. %v\n", err)
+		}
+	}
+}
+
+func appendAudit(b []byte) error {
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+
+	path := getAuditPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(b, '\n'))
+	return err
+}
+
+// loadAudit reads the audit log from the newest.
+func loadAudit() ([]*auditRecord, error) {
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+
+	path := getAuditPath()
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []*auditRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var rec auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("audit log load failed. %v: %v", path, err)
+		}
+		records = append(records, &rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+	return records, nil
+}
+
+func (f *auditFilter) match(r *auditRecord) bool {
+	if f.BotId != "" && r.BotId != f.BotId {
+		return false
+	}
+	if f.Operation != "" && r.Operation != f.Operation {
+		return false
+	}
+	if f.User != "" && r.User != f.User {
+		return false
+	}
+	if f.Profile != "" && r.Profile != f.Profile {
+		return false
+	}
+	if !f.Since.IsZero() && r.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !r.Time.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+func auditCommand(args []string) {
+	var formatType string
+	var botId string
+	var operation string
+	var userName string
+	var profile string
+	var since string
+	var until string
+	var limit int
+
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	fs.StringVar(&formatType, "f", "text", "output format type")
+	fs.StringVar(&botId, "b", "", "filter by bot id")
+	fs.StringVar(&operation, "op", "", "filter by operation")
+	fs.StringVar(&userName, "user", "", "filter by user")
+	fs.StringVar(&profile, "profile", "", "filter by profile")
+	fs.StringVar(&since, "since", "", "filter records since the time")
+	fs.StringVar(&until, "until", "", "filter records until the time")
+	fs.IntVar(&limit, "n", 20, "maximum number of records")
+
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, `Usage: cbot-cli audit [OPTION]... list
+  Listing runs and aborts requested from this CLI from the newest.
+  Set CBOT_AUDIT_SYSLOG=1(or a tag) to forward the records to syslog too.
+  Options:
+    -b BOT         : filter by bot.
+    -op OPERATION  : filter by operation.(run, abort)
+    -user NAME     : filter by OS user.
+    -profile NAME  : filter by profile.
+    -since TIME    : filter records since TIME.(ex: 2006-01-02, '2006-01-02 15:04', 24h, 7d)
+    -until TIME    : filter records before TIME.
+    -n NUMBER      : maximum number of records. 0 is unlimited.[default 20]
+    -f json | text : output format type.[default 'text']
+`)
+	}
+
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.Arg(0) != "list" {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	if botId != "" {
+		// references which can not be resolved are matched as they are.
+		if id, err := resolveBotId(commandContext(), botId); err == nil {
+			botId = id
+		}
+	}
+
+	filter := auditFilter{BotId: botId, Operation: operation, User: userName, Profile: profile, Limit: limit}
+	var err error
+	if filter.Since, err = parseTimeArg(since); err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		os.Exit(1)
+	}
+	if filter.Until, err = parseTimeArg(until); err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		os.Exit(1)
+	}
+
+	if err := execListingAudit(filter, formatType); err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		os.Exit(1)
+	}
+}
+
+func execListingAudit(filter auditFilter, format string) error {
+	records, err := loadAudit()
+	if err != nil {
+		return err
+	}
+
+	matched := []*auditRecord{}
+	for _, r := range records {
+		if filter.Limit > 0 && len(matched) >= filter.Limit {
+			break
+		}
+		if filter.match(r) {
+			matched = append(matched, r)
+		}
+	}
+
+	if format != "text" {
+		b, err := json.MarshalIndent(matched, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+
+	fmt.Println("time\tuser\thost\tprofile\toperation\tbot_id\tjob_id\tcode\tresult")
+	for _, r := range matched {
+		fmt.Printf("%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n", r.Time.Local().Format("2006-01-02 15:04:05"), r.User, r.Host, r.Profile, r.Operation, r.BotId, r.JobId, r.Code, r.Result)
+	}
+	return nil
+}
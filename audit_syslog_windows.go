@@ -0,0 +1,13 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"errors"
+)
+
+// writeSyslog is not supported since windows has no syslog.
+func writeSyslog(tag string, message string) error {
+	return errors.New("syslog is not supported on windows")
+}
@@ -0,0 +1,469 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	BatchResultStatusFailed = "failed"
+
+	MaxBatchSubmitRetries = 5
+	MaxBatchRate          = 100
+)
+
+var (
+	batchResultHeader = []string{"row", "job_id", "status", "output", "error"}
+)
+
+type batchOption struct {
+	File        string
+	ResultFile  string
+	ColumnMap   string
+	Concurrency int
+	Rate        float64
+	Wait        bool
+	Interval    time.Duration
+
+	follower *jobFollower
+}
+
+type batchRecord struct {
+	Row   int
+	Input map[string]string
+}
+
+type batchResult struct {
+	Row    int
+	JobId  string
+	Status string
+	Output string
+	Error  string
+}
+
+func (r *batchResult) csvRecord() []string {
+	return []string{strconv.Itoa(r.Row), r.JobId, r.Status, r.Output, r.Error}
+}
+
+func batchPortal(botId string, param execParameter, opt batchOption) {
+	err := execBatch(commandContext(), botId, param, opt)
+	if err == UnauthorizedError {
+		fmt.Fprintf(os.Stderr, "unauthorized error returned. Check your access token and key.")
+		os.Exit(1)
+	} else if err == ForbiddenError {
+		fmt.Fprintf(os.Stderr, "forbidden error returned. Do you have a bot execute authorize?")
+		os.Exit(1)
+	} else if err == BotNotFoundError {
+		fmt.Fprintf(os.Stderr, "bot id '%s' is not found.", botId)
+		os.Exit(1)
+	} else if err == InterruptedError {
+		fmt.Fprintf(os.Stderr, "interrupted. Run the same command again to resume the batch.")
+		os.Exit(InterruptExitCode)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		os.Exit(1)
+	}
+}
+
+func parseColumnMap(s string) (map[string]string, error) {
+	m := make(map[string]string)
+	if s == "" {
+		return m, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		v := strings.Split(pair, ":")
+		if len(v) != 2 {
+			return nil, fmt.Errorf("invalidate column mapping '%s'. Ex: column:key", pair)
+		}
+		m[v[0]] = v[1]
+	}
+	return m, nil
+}
+
+func readBatchRecords(path string, columnMap map[string]string) ([]batchRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ndjson", ".jsonl":
+		return readNDJSONRecords(f, columnMap)
+	default:
+		return readCSVRecords(f, columnMap)
+	}
+}
+
+func mapColumn(columnMap map[string]string, column string) string {
+	if key, ok := columnMap[column]; ok {
+		return key
+	}
+	return column
+}
+
+func readCSVRecords(r io.Reader, columnMap map[string]string) ([]batchRecord, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("batch file is empty.")
+	} else if err != nil {
+		return nil, err
+	}
+
+	var records []batchRecord
+	for row := 1; ; row++ {
+		values, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		input := make(map[string]string)
+		for i, column := range header {
+			input[mapColumn(columnMap, column)] = values[i]
+		}
+		records = append(records, batchRecord{Row: row, Input: input})
+	}
+	return records, nil
+}
+
+func readNDJSONRecords(r io.Reader, columnMap map[string]string) ([]batchRecord, error) {
+	var records []batchRecord
+	scanner := bufio.NewScanner(r)
+	for row := 1; scanner.Scan(); row++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			row--
+			continue
+		}
+
+		var obj map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &obj); err != nil {
+			return nil, fmt.Errorf("batch record %d: %v", row, err)
+		}
+
+		input := make(map[string]string)
+		for k, v := range obj {
+			if s, ok := v.(string); ok {
+				input[mapColumn(columnMap, k)] = s
+			} else {
+				input[mapColumn(columnMap, k)] = fmt.Sprint(v)
+			}
+		}
+		records = append(records, batchRecord{Row: row, Input: input})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// readBatchResults loads the result file of a previous run.
+// When a row is recorded more than once, the last record wins.
+func readBatchResults(path string) (map[int]batchResult, error) {
+	results := make(map[int]batchResult)
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return results, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cr := csv.NewReader(f)
+	cr.FieldsPerRecord = len(batchResultHeader)
+	for {
+		values, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("result file load failed. %v: %v", path, err)
+		}
+
+		row, err := strconv.Atoi(values[0])
+		if err != nil {
+			// header
+			continue
+		}
+		results[row] = batchResult{
+			Row:    row,
+			JobId:  values[1],
+			Status: values[2],
+			Output: values[3],
+			Error:  values[4],
+		}
+	}
+	return results, nil
+}
+
+type batchResultWriter struct {
+	mu sync.Mutex
+	f  *os.File
+	w  *csv.Writer
+}
+
+func openBatchResultWriter(path string) (*batchResultWriter, error) {
+	ok, err := isExist(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &batchResultWriter{f: f, w: csv.NewWriter(f)}
+	if !ok {
+		w.w.Write(batchResultHeader)
+		w.w.Flush()
+	}
+	return w, nil
+}
+
+func (w *batchResultWriter) Write(r batchResult) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.w.Write(r.csvRecord())
+	w.w.Flush()
+	return w.w.Error()
+}
+
+func (w *batchResultWriter) Close() error {
+	return w.f.Close()
+}
+
+// submitWithRetry submits the job and retries with backoff while the
+// server answers too many requests.
+func submitWithRetry(ctx context.Context, botId string, param execParameter) (*execBotResponse, error) {
+	backoff := time.Second
+	for i := 0; ; i++ {
+		ret, _, err := runBot(ctx, botId, param)
+		if err != TooManyExecuteRequestError || i >= MaxBatchSubmitRetries {
+			return ret, err
+		}
+		if err := sleepContext(ctx, backoff); err != nil {
+			return nil, err
+		}
+		backoff *= 2
+	}
+}
+
+func compactOutput(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, raw); err != nil {
+		return string(raw)
+	}
+	return buf.String()
+}
+
+// processBatchRecord submits and follows the record. The error of ctx
+// stops the batch without marking the record failed, so that the batch
+// resumes it.
+func processBatchRecord(ctx context.Context, botId string, param execParameter, rec batchRecord, prev batchResult, opt batchOption, throttle <-chan time.Time, out *batchResultWriter) (batchResult, error) {
+	result := prev
+	result.Row = rec.Row
+
+	if result.JobId == "" {
+		select {
+		case <-throttle:
+		case <-ctx.Done():
+			return result, ctx.Err()
+		}
+
+		param.Input = rec.Input
+		ret, err := submitWithRetry(ctx, botId, param)
+		if err == UnauthorizedError || err == ForbiddenError || err == BotNotFoundError {
+			return result, err
+		} else if ctx.Err() != nil {
+			return result, ctx.Err()
+		} else if err != nil {
+			result.Status = BatchResultStatusFailed
+			result.Error = err.Error()
+			return result, out.Write(result)
+		}
+
+		result.JobId = ret.JobId
+		result.Status = jobStatusString(ret.Status)
+		result.Error = ""
+		if err := out.Write(result); err != nil {
+			return result, err
+		}
+	}
+
+	if !opt.Wait || result.Status != jobStatusString(JobStatusRunning) {
+		return result, nil
+	}
+
+	job, err := opt.follower.waitJob(result.JobId, opt.Interval)
+	if ctx.Err() != nil {
+		return result, ctx.Err()
+	} else if err != nil {
+		result.Error = err.Error()
+		return result, out.Write(result)
+	}
+	result.Status = job.StatusString()
+	result.Output = compactOutput(job.Output)
+	result.Error = job.Message
+	return result, out.Write(result)
+}
+
+func needsBatchProcessing(prev batchResult, ok bool, wait bool) bool {
+	if !ok || prev.JobId == "" {
+		return true
+	}
+	return wait && prev.Status == jobStatusString(JobStatusRunning)
+}
+
+func execBatch(ctx context.Context, botId string, param execParameter, opt batchOption) error {
+	if err := startBulkOperation(); err != nil {
+		return err
+	}
+
+	if opt.Concurrency < 1 {
+		return errors.New("concurrency must be 1 or more.")
+	}
+	if !(opt.Rate > 0 && opt.Rate <= MaxBatchRate) {
+		return fmt.Errorf("rate must be greater than 0 and %d or less.", MaxBatchRate)
+	}
+	if opt.Interval <= 0 {
+		opt.Interval = DefaultWaitInterval
+	}
+	if opt.ResultFile == "" {
+		opt.ResultFile = opt.File + ".result.csv"
+	}
+
+	columnMap, err := parseColumnMap(opt.ColumnMap)
+	if err != nil {
+		return err
+	}
+
+	// -i gives the defaults of the records
+	if err := setupParameter(&param); err != nil {
+		return errors.New("parameter format is invalidate. Ex: key:value")
+	}
+
+	records, err := readBatchRecords(opt.File, columnMap)
+	if err != nil {
+		return err
+	}
+	for _, rec := range records {
+		for k, v := range param.Input {
+			if _, ok := rec.Input[k]; !ok {
+				rec.Input[k] = v
+			}
+		}
+		if err := validateInput(ctx, botId, rec.Input); err != nil {
+			return fmt.Errorf("batch record %d: %v", rec.Row, err)
+		}
+	}
+
+	previous, err := readBatchResults(opt.ResultFile)
+	if err != nil {
+		return err
+	}
+
+	out, err := openBatchResultWriter(opt.ResultFile)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if opt.Wait {
+		opt.follower = newJobFollower(ctx)
+		defer opt.follower.Stop()
+		// Ctrl-C stops the submissions too
+		ctx = opt.follower.ctx
+	}
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / opt.Rate))
+	defer ticker.Stop()
+
+	queue := make(chan batchRecord)
+	done := make(chan struct{})
+	var once sync.Once
+	var fatal error
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	counts := make(map[string]int)
+
+	for i := 0; i < opt.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rec := range queue {
+				result, err := processBatchRecord(ctx, botId, param, rec, previous[rec.Row], opt, ticker.C, out)
+				if err != nil {
+					once.Do(func() {
+						fatal = err
+						close(done)
+					})
+					return
+				}
+
+				mu.Lock()
+				counts[result.Status]++
+				mu.Unlock()
+				fmt.Fprintf(os.Stderr, "row %d: %s %s\n", result.Row, result.JobId, result.Status)
+			}
+		}()
+	}
+
+	skipped := 0
+feed:
+	for _, rec := range records {
+		prev, ok := previous[rec.Row]
+		if !needsBatchProcessing(prev, ok, opt.Wait) {
+			skipped++
+			continue
+		}
+		select {
+		case queue <- rec:
+		case <-done:
+			break feed
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if fatal == nil {
+		fatal = ctx.Err()
+	}
+	if opt.follower != nil && opt.follower.isInterrupted() {
+		return opt.follower.interruptedError()
+	}
+	if fatal == context.DeadlineExceeded {
+		return fmt.Errorf("timed out. Run the same command again to resume the batch from %s", opt.ResultFile)
+	} else if fatal != nil {
+		return fatal
+	}
+
+	fmt.Fprintf(os.Stderr, "%d rows processed, %d skipped.", len(records)-skipped, skipped)
+	for status, n := range counts {
+		fmt.Fprintf(os.Stderr, " %s: %d", status, n)
+	}
+	fmt.Fprintf(os.Stderr, "\nresults are written to %s\n", opt.ResultFile)
+
+	return nil
+}
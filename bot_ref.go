@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ambiguousBotError is returned when a bot name matches several bots.
+type ambiguousBotError struct {
+	Ref        string
+	Candidates []listingBotsResponseBot
+}
+
+func (e *ambiguousBotError) Error() string {
+	lines := []string{fmt.Sprintf("bot '%s' is ambiguous. Candidates are:", e.Ref)}
+	for _, b := range e.Candidates {
+		lines = append(lines, fmt.Sprintf("  %s\t%s", b.Id, b.Name))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// matchBotRef finds the bot by id, exact name or unique name prefix.
+// Names are compared case-insensitively when no exact match exists.
+func matchBotRef(ref string, bots []listingBotsResponseBot) (string, bool, error) {
+	for _, b := range bots {
+		if b.Id == ref {
+			return b.Id, true, nil
+		}
+	}
+
+	matchers := []func(name string) bool{
+		func(name string) bool { return name == ref },
+		func(name string) bool { return strings.EqualFold(name, ref) },
+		func(name string) bool { return strings.HasPrefix(name, ref) },
+		func(name string) bool { return strings.HasPrefix(strings.ToLower(name), strings.ToLower(ref)) },
+	}
+	for _, match := range matchers {
+		var candidates []listingBotsResponseBot
+		for _, b := range bots {
+			if match(b.Name) {
+				candidates = append(candidates, b)
+			}
+		}
+		switch len(candidates) {
+		case 0:
+			continue
+		case 1:
+			return candidates[0].Id, true, nil
+		default:
+			sort.Slice(candidates, func(i, j int) bool {
+				return candidates[i].Name < candidates[j].Name
+			})
+			return "", false, &ambiguousBotError{Ref: ref, Candidates: candidates}
+		}
+	}
+	return "", false, nil
+}
+
+// resolveBotId resolves a bot id, an alias in the config or a bot name
+// to the bot id. Unknown references are returned as they are so that
+// bots hidden from the listing can still be specified by id.
+func resolveBotId(ctx context.Context, ref string) (string, error) {
+	if id, ok := UserConfig.Aliases[ref]; ok {
+		return id, nil
+	}
+
+	bots, _, err := cachedFetchBots(ctx)
+	if err == ForbiddenError || err == OfflineCacheMissError {
+		// no reference authorize. only ids are usable.
+		return ref, nil
+	} else if err != nil {
+		return "", err
+	}
+
+	id, ok, err := matchBotRef(ref, bots.Bots)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return ref, nil
+	}
+	return id, nil
+}
+
+// resolveExactBotId is resolveBotId without the name prefix matching.
+// It resolves the bot given in place of a command, where a mistyped
+// command must not run a bot. ok is false when nothing matches.
+func resolveExactBotId(ctx context.Context, ref string) (string, bool, error) {
+	if id, ok := UserConfig.Aliases[ref]; ok {
+		return id, true, nil
+	}
+
+	bots, _, err := cachedFetchBots(ctx)
+	if err == ForbiddenError || err == OfflineCacheMissError {
+		// no reference authorize. only ids are usable.
+		return ref, true, nil
+	} else if err != nil {
+		return "", false, err
+	}
+
+	var candidates []listingBotsResponseBot
+	for _, b := range bots.Bots {
+		if b.Id == ref {
+			return b.Id, true, nil
+		}
+		if b.Name == ref {
+			candidates = append(candidates, b)
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		return "", false, nil
+	case 1:
+		return candidates[0].Id, true, nil
+	}
+	return "", false, &ambiguousBotError{Ref: ref, Candidates: candidates}
+}
+
+func resolveBotIdPortal(ref string) string {
+	id, err := resolveBotId(commandContext(), ref)
+	if err == UnauthorizedError {
+		fmt.Fprintf(os.Stderr, "unauthorized error returned. Check your access token and key.")
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		os.Exit(1)
+	}
+	return id
+}
+
+func aliasCommand(args []string) {
+	usage := func() {
+		fmt.Fprint(os.Stderr, `Usage: cbot-cli alias list
+       cbot-cli alias set NAME [BOT]
+       cbot-cli alias unset NAME
+  Aliases can be used instead of bot ids. BOT is a bot id or a bot name.
+  On a terminal, BOT is picked from a list when omitted.
+`)
+		os.Exit(1)
+	}
+	if len(args) < 1 {
+		usage()
+	}
+
+	switch args[0] {
+	case "list":
+		names := make([]string, 0, len(UserConfig.Aliases))
+		for name := range UserConfig.Aliases {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		fmt.Println("alias\tbot_id")
+		for _, name := range names {
+			fmt.Printf("%s\t%s\n", name, UserConfig.Aliases[name])
+		}
+		return
+	case "set":
+		if len(args) < 2 {
+			usage()
+		}
+		id, ok := botArgPortal(args[2:])
+		if !ok {
+			usage()
+		}
+		if UserConfig.Aliases == nil {
+			UserConfig.Aliases = make(map[string]string)
+		}
+		UserConfig.Aliases[args[1]] = id
+	case "unset":
+		if len(args) < 2 {
+			usage()
+		}
+		if _, ok := UserConfig.Aliases[args[1]]; !ok {
+			fmt.Fprintf(os.Stderr, "alias '%s' is not found.", args[1])
+			os.Exit(1)
+		}
+		delete(UserConfig.Aliases, args[1])
+	default:
+		usage()
+	}
+
+	if err := saveConfig(UserConfig); err != nil {
+		fmt.Fprintf(os.Stderr, "config file save failed.\n%v", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func botsCommand(args []string) {
+	usage := func() {
+		fmt.Fprint(os.Stderr, `Usage: cbot-cli bots diff [OPTION]...
+       cbot-cli bots export DIR
+  Compare or export the bot definitions.
+  (see 'cbot-cli bots diff -h' and 'cbot-cli bots export -h')
+`)
+		os.Exit(1)
+	}
+	if len(args) < 1 {
+		usage()
+	}
+
+	switch args[0] {
+	case "diff":
+		botsDiffCommand(args[1:])
+	case "export":
+		botsExportCommand(args[1:])
+	default:
+		usage()
+	}
+}
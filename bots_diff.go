@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	BotDiffMissing   = "missing"
+	BotDiffExtra     = "extra"
+	BotDiffChanged   = "changed"
+	BotDiffDuplicate = "duplicate"
+
+	// like diff(1)
+	BotsDiffFoundExitCode = 1
+	BotsDiffErrorExitCode = 2
+)
+
+type botDiffChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+type botDiff struct {
+	Name    string          `json:"name"`
+	Status  string          `json:"status"`
+	FromId  string          `json:"from_id,omitempty"`
+	ToId    string          `json:"to_id,omitempty"`
+	Changes []botDiffChange `json:"changes,omitempty"`
+}
+
+type botsDiffReport struct {
+	From string    `json:"from"`
+	To   string    `json:"to"`
+	Same int       `json:"same"`
+	Bots []botDiff `json:"bots"`
+}
+
+func botsDiffCommand(args []string) {
+	var from string
+	var to string
+	var formatType string
+
+	fs := flag.NewFlagSet("bots diff", flag.ExitOnError)
+	fs.StringVar(&from, "from", "", "profile to compare from")
+	fs.StringVar(&to, "to", "", "profile to compare to")
+	fs.StringVar(&formatType, "f", "text", "output format type")
+
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, `Usage: cbot-cli bots diff [OPTION]... -to PROFILE
+  Compare the bots of two profiles matched by the bot name.
+  Missing bots, description changes and the differences of the input
+  and output definitions are reported.
+  Exits 1 when the bots differ and 2 on errors, like diff.
+  Options:
+    -from PROFILE  : profile to compare from.[default current profile]
+    -to PROFILE    : profile to compare to.
+    -f json | text : output format type.[default 'text']
+`)
+	}
+	fs.Parse(args)
+
+	if from == "" {
+		from = currentProfileName()
+	}
+	if to == "" || fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(BotsDiffErrorExitCode)
+	}
+	if from == to {
+		fmt.Fprintf(os.Stderr, "-from and -to are the same profile '%s'.", from)
+		os.Exit(BotsDiffErrorExitCode)
+	}
+	if formatType != "json" && formatType != "text" {
+		fmt.Fprintf(os.Stderr, "invalidate format '%s'. Use json or text.", formatType)
+		os.Exit(BotsDiffErrorExitCode)
+	}
+
+	report, err := execBotsDiff(commandContext(), from, to)
+	if err == nil {
+		err = printBotsDiff(report, formatType)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		os.Exit(BotsDiffErrorExitCode)
+	}
+	if len(report.Bots) > 0 {
+		os.Exit(BotsDiffFoundExitCode)
+	}
+}
+
+// fetchBotDefinitions fetches the bots of the profile with their input
+// and output definitions. The caches are not used since the definitions
+// are compared.
+func fetchBotDefinitions(ctx context.Context, profile string) ([]*showBotResponse, error) {
+	restore, err := useProfile(profile)
+	if err != nil {
+		return nil, err
+	}
+	defer restore()
+
+	bots, _, err := fetchBots(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("profile '%s': listing bots failed. %v", profile, err)
+	}
+
+	var ret []*showBotResponse
+	for _, b := range bots.Bots {
+		bot, _, err := fetchBot(ctx, b.Id)
+		if err != nil {
+			return nil, fmt.Errorf("profile '%s': bot id '%s' fetch failed. %v", profile, b.Id, err)
+		}
+		ret = append(ret, bot)
+	}
+	return ret, nil
+}
+
+func groupBotsByName(bots []*showBotResponse) map[string][]*showBotResponse {
+	ret := make(map[string][]*showBotResponse)
+	for _, b := range bots {
+		ret[b.Name] = append(ret[b.Name], b)
+	}
+	return ret
+}
+
+func execBotsDiff(ctx context.Context, from string, to string) (*botsDiffReport, error) {
+	fromBots, err := fetchBotDefinitions(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+	toBots, err := fetchBotDefinitions(ctx, to)
+	if err != nil {
+		return nil, err
+	}
+	return diffBots(from, to, fromBots, toBots), nil
+}
+
+func diffBots(from string, to string, fromBots []*showBotResponse, toBots []*showBotResponse) *botsDiffReport {
+	report := &botsDiffReport{From: from, To: to, Bots: []botDiff{}}
+	fromByName := groupBotsByName(fromBots)
+	toByName := groupBotsByName(toBots)
+
+	names := make(map[string]bool)
+	for name := range fromByName {
+		names[name] = true
+	}
+	for name := range toByName {
+		names[name] = true
+	}
+	var sorted []string
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		f, t := fromByName[name], toByName[name]
+		switch {
+		case len(f) > 1 || len(t) > 1:
+			// bots with the same name can not be matched
+			report.Bots = append(report.Bots, botDiff{Name: name, Status: BotDiffDuplicate, FromId: joinBotIds(f), ToId: joinBotIds(t)})
+		case len(t) == 0:
+			report.Bots = append(report.Bots, botDiff{Name: name, Status: BotDiffMissing, FromId: f[0].Id})
+		case len(f) == 0:
+			report.Bots = append(report.Bots, botDiff{Name: name, Status: BotDiffExtra, ToId: t[0].Id})
+		default:
+			changes := diffBotDefinition(f[0], t[0])
+			if len(changes) == 0 {
+				report.Same++
+				continue
+			}
+			report.Bots = append(report.Bots, botDiff{Name: name, Status: BotDiffChanged, FromId: f[0].Id, ToId: t[0].Id, Changes: changes})
+		}
+	}
+	return report
+}
+
+func joinBotIds(bots []*showBotResponse) string {
+	var ids []string
+	for _, b := range bots {
+		ids = append(ids, b.Id)
+	}
+	return strings.Join(ids, ",")
+}
+
+func diffBotDefinition(from *showBotResponse, to *showBotResponse) []botDiffChange {
+	var changes []botDiffChange
+	if from.Description != to.Description {
+		changes = append(changes, botDiffChange{Field: "description", From: from.Description, To: to.Description})
+	}
+	changes = append(changes, diffBotParameters("input", from.Input, to.Input)...)
+	changes = append(changes, diffBotParameters("output", from.Output, to.Output)...)
+	return changes
+}
+
+// botParameterDefinitions returns the definitions of the parameters
+// keyed by the name with all the properties Cloud Bot answers, not only
+// the ones cbot-cli knows.
+func botParameterDefinitions(raw json.RawMessage) map[string]map[string]interface{} {
+	ret := make(map[string]map[string]interface{})
+	if len(raw) == 0 {
+		return ret
+	}
+
+	var list []map[string]interface{}
+	if err := json.Unmarshal(raw, &list); err == nil {
+		for _, p := range list {
+			if name, ok := p["name"].(string); ok {
+				ret[name] = p
+			}
+		}
+		return ret
+	}
+
+	var obj map[string]interface{}
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return ret
+	}
+	for name, v := range obj {
+		p, ok := v.(map[string]interface{})
+		if !ok {
+			p = map[string]interface{}{"value": v}
+		}
+		ret[name] = p
+	}
+	return ret
+}
+
+// diffBotParameters compares the parameters by the name. The order of
+// the parameters is not compared.
+func diffBotParameters(kind string, from json.RawMessage, to json.RawMessage) []botDiffChange {
+	fromByName := botParameterDefinitions(from)
+	toByName := botParameterDefinitions(to)
+	names := make(map[string]bool)
+	for name := range fromByName {
+		names[name] = true
+	}
+	for name := range toByName {
+		names[name] = true
+	}
+	var sorted []string
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	var changes []botDiffChange
+	for _, name := range sorted {
+		f, fok := fromByName[name]
+		t, tok := toByName[name]
+		if fok && tok && reflect.DeepEqual(f, t) {
+			continue
+		}
+		c := botDiffChange{Field: kind + " " + name}
+		if fok {
+			c.From = f
+		}
+		if tok {
+			c.To = t
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+func printBotsDiff(report *botsDiffReport, format string) error {
+	if format != "text" {
+		b, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+
+	for _, d := range report.Bots {
+		switch d.Status {
+		case BotDiffMissing:
+			fmt.Printf("- %s (%s): only in %s\n", d.Name, d.FromId, report.From)
+		case BotDiffExtra:
+			fmt.Printf("+ %s (%s): only in %s\n", d.Name, d.ToId, report.To)
+		case BotDiffDuplicate:
+			fmt.Printf("! %s: not unique. %s: [%s] %s: [%s]\n", d.Name, report.From, d.FromId, report.To, d.ToId)
+		case BotDiffChanged:
+			fmt.Printf("~ %s (%s -> %s)\n", d.Name, d.FromId, d.ToId)
+			for _, c := range d.Changes {
+				fmt.Printf("    %s: %s\n", c.Field, describeBotDiffChange(c))
+			}
+		}
+	}
+	fmt.Printf("%d bots differ, %d bots are the same. (%s -> %s)\n", len(report.Bots), report.Same, report.From, report.To)
+	return nil
+}
+
+func describeBotDiffChange(c botDiffChange) string {
+	switch {
+	case c.From == nil:
+		return "added"
+	case c.To == nil:
+		return "removed"
+	}
+
+	f, fok := c.From.(map[string]interface{})
+	t, tok := c.To.(map[string]interface{})
+	if !fok || !tok {
+		return fmt.Sprintf("%q -> %q", c.From, c.To)
+	}
+
+	keys := make(map[string]bool)
+	for k := range f {
+		keys[k] = true
+	}
+	for k := range t {
+		keys[k] = true
+	}
+	var sorted []string
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var diffs []string
+	for _, k := range sorted {
+		if !reflect.DeepEqual(f[k], t[k]) {
+			diffs = append(diffs, fmt.Sprintf("%s %s -> %s", k, jsonValueString(f[k]), jsonValueString(t[k])))
+		}
+	}
+	return strings.Join(diffs, ", ")
+}
+
+func jsonValueString(v interface{}) string {
+	if v == nil {
+		return "(none)"
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+const (
+	BotsExportIndexFileName = "index.json"
+	BotsExportBotsDirName   = "bots"
+)
+
+var (
+	unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+)
+
+type botsExportIndexBot struct {
+	Id           string   `json:"id"`
+	Name         string   `json:"name"`
+	Description  string   `json:"description"`
+	LastModified string   `json:"last_modified"`
+	Inputs       []string `json:"inputs"`
+	Outputs      []string `json:"outputs"`
+	File         string   `json:"file"`
+}
+
+type botsExportIndex struct {
+	Bots []botsExportIndexBot `json:"bots"`
+}
+
+type botsExportResult struct {
+	Added     int
+	Updated   int
+	Unchanged int
+	Removed   int
+}
+
+func botsExportCommand(args []string) {
+	fs := flag.NewFlagSet("bots export", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, `Usage: cbot-cli bots export DIR
+  Write the bots of the current profile to DIR to commit it to git.
+  DIR/bots/BOT_ID.json has the metadata and the input and output
+  definitions of the bot, and DIR/index.json lists the bots.
+  The output is ordered deterministically, and files of the bots which
+  no longer exist are removed.
+`)
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	dir := fs.Arg(0)
+
+	result, err := execBotsExport(commandContext(), dir)
+	if err == UnauthorizedError {
+		fmt.Fprintf(os.Stderr, "unauthorized error returned. Check your access token and key.")
+		os.Exit(1)
+	} else if err == ForbiddenError {
+		fmt.Fprintf(os.Stderr, "forbidden error returned. Do you have a reference authorize?")
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "bots are exported to %s. %d added, %d updated, %d unchanged, %d removed.\n",
+		dir, result.Added, result.Updated, result.Unchanged, result.Removed)
+}
+
+func botExportFileName(botId string) string {
+	return unsafeFileNameChars.ReplaceAllString(botId, "_") + ".json"
+}
+
+// canonicalBotJSON formats the show bot response without the response
+// code. The keys are sorted and the parameters keep the order of
+// Cloud Bot, so that the same bot is always written the same.
+func canonicalBotJSON(body []byte) ([]byte, error) {
+	var m map[string]interface{}
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, err
+	}
+	delete(m, "code")
+	return marshalExportJSON(m)
+}
+
+func marshalExportJSON(v interface{}) ([]byte, error) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+func parameterNames(params []botParameter) []string {
+	names := []string{}
+	for _, p := range params {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+// writeExportFile writes the file only when the content changes, and
+// reports whether the file existed.
+func writeExportFile(path string, data []byte) (existed bool, changed bool, err error) {
+	old, err := ioutil.ReadFile(path)
+	if err == nil && bytes.Equal(old, data) {
+		return true, false, nil
+	}
+	return err == nil, true, writeFileAtomic(path, data, 0644)
+}
+
+// loadExportIndex reads the index of the previous export. It is empty
+// when DIR is exported for the first time.
+func loadExportIndex(path string) (*botsExportIndex, error) {
+	var index botsExportIndex
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &index, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &index); err != nil {
+		return nil, fmt.Errorf("previous index load failed. %v: %v", path, err)
+	}
+	return &index, nil
+}
+
+func execBotsExport(ctx context.Context, dir string) (*botsExportResult, error) {
+	bots, _, err := fetchBots(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	botsDir := filepath.Join(dir, BotsExportBotsDirName)
+	if err := os.MkdirAll(botsDir, 0755); err != nil {
+		return nil, err
+	}
+
+	list := bots.Bots
+	sort.Slice(list, func(i, j int) bool { return list[i].Id < list[j].Id })
+
+	result := &botsExportResult{}
+	index := botsExportIndex{Bots: []botsExportIndexBot{}}
+	written := make(map[string]bool)
+	for _, b := range list {
+		bot, body, err := fetchBot(ctx, b.Id)
+		if err != nil {
+			return nil, fmt.Errorf("bot id '%s' fetch failed. %v", b.Id, err)
+		}
+		data, err := canonicalBotJSON(body)
+		if err != nil {
+			return nil, err
+		}
+
+		name := botExportFileName(bot.Id)
+		if written[name] {
+			return nil, fmt.Errorf("bot ids conflict as the file name %s.", name)
+		}
+		written[name] = true
+
+		existed, changed, err := writeExportFile(filepath.Join(botsDir, name), data)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case !existed:
+			result.Added++
+		case changed:
+			result.Updated++
+		default:
+			result.Unchanged++
+		}
+
+		index.Bots = append(index.Bots, botsExportIndexBot{
+			Id:           bot.Id,
+			Name:         bot.Name,
+			Description:  bot.Description,
+			LastModified: bot.LastModified,
+			Inputs:       parameterNames(bot.InputParameters()),
+			Outputs:      parameterNames(bot.OutputParameters()),
+			File:         BotsExportBotsDirName + "/" + name,
+		})
+	}
+
+	// the bots removed from Cloud Bot. only the files of the previous
+	// export are removed so that the files of the user are kept.
+	prev, err := loadExportIndex(filepath.Join(dir, BotsExportIndexFileName))
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range prev.Bots {
+		name := path.Base(b.File)
+		if b.File != BotsExportBotsDirName+"/"+name || written[name] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(botsDir, name)); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		result.Removed++
+	}
+
+	data, err := marshalExportJSON(index)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := writeExportFile(filepath.Join(dir, BotsExportIndexFileName), data); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
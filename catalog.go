@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	CacheDirName       = "cache"
+	CatalogFileName    = "catalog.json"
+	DefaultCatalogTTL  = 10 * time.Minute
+	CatalogTTLEnvName  = "CBOT_CACHE_TTL"
+	OfflineModeEnvName = "CBOT_OFFLINE"
+)
+
+var (
+	// set by the global --refresh and --offline options
+	CatalogRefresh bool
+	CatalogOffline bool
+
+	OfflineCacheMissError = errors.New("Specified data is not cached. Run without offline mode first")
+
+	catalogMutex sync.Mutex
+)
+
+// catalogCache is the on-disk cache of the bot listing and bot details.
+// Raw response bodies are kept so that cached output is the same as
+// the output of the API.
+type catalogCache struct {
+	BotsTime time.Time                 `json:"bots_time"`
+	Bots     json.RawMessage           `json:"bots"`
+	Details  map[string]*catalogDetail `json:"details"`
+}
+
+type catalogDetail struct {
+	Time time.Time       `json:"time"`
+	Body json.RawMessage `json:"body"`
+}
+
+func getCatalogPath() string {
+	return filepath.Join(getConfigDir(), CacheDirName, currentProfileName(), CatalogFileName)
+}
+
+func catalogTTL() time.Duration {
+	if v := os.Getenv(CatalogTTLEnvName); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return DefaultCatalogTTL
+}
+
+func loadCatalog() *catalogCache {
+	c := catalogCache{Details: make(map[string]*catalogDetail)}
+	b, err := ioutil.ReadFile(getCatalogPath())
+	if err == nil {
+		json.Unmarshal(b, &c)
+	}
+	if c.Details == nil {
+		c.Details = make(map[string]*catalogDetail)
+	}
+	return &c
+}
+
+func (c *catalogCache) save() {
+	path := getCatalogPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return
+	}
+	ioutil.WriteFile(path, b, 0600)
+}
+
+func isFresh(t time.Time) bool {
+	return !CatalogRefresh && time.Since(t) < catalogTTL()
+}
+
+// isConnectivityError reports whether the error is not an answer of
+// the server, in which case stale cache is used instead.
+func isConnectivityError(err error) bool {
+	switch err {
+	case UnauthorizedError, ForbiddenError, BotNotFoundError:
+		return false
+	}
+	var codeErr *responseCodeError
+	return !errors.As(err, &codeErr)
+}
+
+// cachedFetchBots is fetchBots served from the catalog cache.
+func cachedFetchBots(ctx context.Context) (*listingBotsResponse, []byte, error) {
+	catalogMutex.Lock()
+	defer catalogMutex.Unlock()
+
+	c := loadCatalog()
+	useCache := len(c.Bots) > 0 && (CatalogOffline || isFresh(c.BotsTime))
+	if CatalogOffline && len(c.Bots) == 0 {
+		return nil, nil, OfflineCacheMissError
+	}
+
+	if !useCache {
+		ret, body, err := fetchBots(ctx)
+		if err == nil {
+			c.Bots = body
+			c.BotsTime = time.Now()
+			c.save()
+			return ret, body, nil
+		}
+		if len(c.Bots) == 0 || !isConnectivityError(err) {
+			return nil, nil, err
+		}
+		fmt.Fprintf(os.Stderr, "using cached bots of %s. %v\n", c.BotsTime.Local().Format("2006-01-02 15:04:05"), err)
+	}
+
+	var ret listingBotsResponse
+	if err := json.Unmarshal(c.Bots, &ret); err != nil {
+		return nil, nil, err
+	}
+	return &ret, c.Bots, nil
+}
+
+// cachedFetchBot is fetchBot served from the catalog cache.
+func cachedFetchBot(ctx context.Context, botId string) (*showBotResponse, []byte, error) {
+	catalogMutex.Lock()
+	defer catalogMutex.Unlock()
+
+	c := loadCatalog()
+	d, ok := c.Details[botId]
+	if CatalogOffline && !ok {
+		return nil, nil, OfflineCacheMissError
+	}
+
+	if !ok || !(CatalogOffline || isFresh(d.Time)) {
+		ret, body, err := fetchBot(ctx, botId)
+		if err == nil {
+			c.Details[botId] = &catalogDetail{Time: time.Now(), Body: body}
+			c.save()
+			return ret, body, nil
+		}
+		if !ok || !isConnectivityError(err) {
+			return nil, nil, err
+		}
+		fmt.Fprintf(os.Stderr, "using cached bot of %s. %v\n", d.Time.Local().Format("2006-01-02 15:04:05"), err)
+	}
+
+	var ret showBotResponse
+	if err := json.Unmarshal(d.Body, &ret); err != nil {
+		return nil, nil, err
+	}
+	return &ret, d.Body, nil
+}
+
+// validateInput checks the input keys against the inputs declared by
+// the bot. The check is skipped when the declaration is unavailable.
+func validateInput(ctx context.Context, botId string, input map[string]string) error {
+	bot, _, err := cachedFetchBot(ctx, botId)
+	if err != nil {
+		return nil
+	}
+
+	declared := bot.InputParameters()
+	if len(declared) == 0 {
+		return nil
+	}
+
+	names := make(map[string]bool)
+	var list []string
+	for _, p := range declared {
+		names[p.Name] = true
+		list = append(list, p.Name)
+	}
+
+	var unknown []string
+	for k := range input {
+		if !names[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	return fmt.Errorf("input '%s' is not declared by bot '%s'. Declared inputs: %s", strings.Join(unknown, "', '"), botId, strings.Join(list, ", "))
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	CompletionCacheFileName = "completion_cache.json"
+	CompletionCacheTTL      = 60 * time.Second
+	// the shell waits for the candidates. stale caches are used after it.
+	CompletionTimeout = 2 * time.Second
+
+	// printed when the shell should complete file names
+	CompletionFileDirective = ":file"
+)
+
+const (
+	completeNone = iota
+	completeBots
+	completeJobs
+	completeFiles
+	completeFormats
+)
+
+// completionSpec describes a command for the shell completion.
+type completionSpec struct {
+	Name        string
+	Description string
+	Flags       []string
+	ValueFlags  map[string]int
+	Actions     []string
+	Args        int
+}
+
+var (
+	topLevelCompletion = &completionSpec{
+		Flags: []string{"-i", "-t", "-u", "-T", "-p", "-r", "-h", "-l", "-s", "-j", "-a", "-f"},
+		ValueFlags: map[string]int{
+			"-i": completeNone, "-t": completeNone, "-u": completeNone, "-T": completeNone,
+			"-s": completeBots, "-j": completeBots, "-a": completeJobs, "-f": completeFormats,
+		},
+		Args: completeBots,
+	}
+
+	subCommandCompletions = []*completionSpec{
+		{
+			Name:        "run",
+			Description: "execute bot",
+			Flags:       []string{"-i", "-t", "-u", "-T", "-wait", "-interval", "-batch", "-map", "-result", "-c", "-rate", "-if-idle", "-single-instance", "-on-running"},
+			ValueFlags: map[string]int{
+				"-i": completeNone, "-t": completeNone, "-u": completeNone, "-T": completeNone, "-interval": completeNone,
+				"-batch": completeFiles, "-map": completeNone, "-result": completeFiles, "-c": completeNone, "-rate": completeNone, "-on-running": completeNone,
+			},
+			Args: completeBots,
+		},
+		{
+			Name:        "scheduler",
+			Description: "trigger bot runs by cron schedules",
+			Flags:       []string{"-f", "-state", "-n"},
+			ValueFlags:  map[string]int{"-f": completeFiles, "-state": completeFiles, "-n": completeNone},
+			Actions:     []string{"run", "list", "next"},
+		},
+		{
+			Name:        "workflow",
+			Description: "run bots in order defined by a workflow file",
+			Flags:       []string{"-p", "-report", "-interval"},
+			ValueFlags:  map[string]int{"-p": completeNone, "-report": completeFiles, "-interval": completeNone},
+			Args:        completeFiles,
+		},
+		{
+			Name:        "history",
+			Description: "listing runs requested from this CLI",
+			Flags:       []string{"-b", "-status", "-profile", "-since", "-until", "-n", "-f"},
+			ValueFlags: map[string]int{
+				"-b": completeBots, "-status": completeNone, "-profile": completeNone, "-since": completeNone,
+				"-until": completeNone, "-n": completeNone, "-f": completeFormats,
+			},
+			Actions: []string{"list", "search", "show"},
+		},
+		{
+			Name:        "stats",
+			Description: "job statistics per bot",
+			Flags:       []string{"-b", "-since", "-until", "-trend", "-f"},
+			ValueFlags: map[string]int{
+				"-b": completeBots, "-since": completeNone, "-until": completeNone, "-trend": completeNone, "-f": completeFormats,
+			},
+		},
+		{
+			Name:        "exporter",
+			Description: "serve Prometheus metrics of bots and jobs",
+			Flags:       []string{"-listen", "-interval", "-b"},
+			ValueFlags:  map[string]int{"-listen": completeNone, "-interval": completeNone, "-b": completeBots},
+		},
+		{
+			Name:        "watch",
+			Description: "wait for the job to finish",
+			Flags:       []string{"-interval"},
+			ValueFlags:  map[string]int{"-interval": completeNone},
+			Args:        completeJobs,
+		},
+		{
+			Name:        "notify",
+			Description: "test the job completion notifications",
+			Flags:       []string{"-f"},
+			ValueFlags:  map[string]int{"-f": completeFiles},
+			Actions:     []string{"test"},
+		},
+		{
+			Name:        "alias",
+			Description: "manage bot aliases",
+			Actions:     []string{"list", "set", "unset"},
+		},
+		{
+			Name:        "bots",
+			Description: "compare or export the bot definitions",
+			Flags:       []string{"-from", "-to", "-f"},
+			ValueFlags:  map[string]int{"-from": completeNone, "-to": completeNone, "-f": completeFormats},
+			Actions:     []string{"diff", "export"},
+		},
+		{
+			Name:        "config",
+			Description: "manage the config without prompts",
+			Actions:     []string{"list", "get", "set", "unset", "profiles", "edit", "restore"},
+		},
+		{
+			Name:        "audit",
+			Description: "listing runs and aborts requested from this CLI",
+			Flags:       []string{"-b", "-op", "-user", "-profile", "-since", "-until", "-n", "-f"},
+			ValueFlags: map[string]int{
+				"-b": completeBots, "-op": completeNone, "-user": completeNone, "-profile": completeNone,
+				"-since": completeNone, "-until": completeNone, "-n": completeNone, "-f": completeFormats,
+			},
+			Actions: []string{"list"},
+		},
+		{
+			Name:        "proxy",
+			Description: "serve the API to the team with per-user tokens",
+			Flags:       []string{"-listen", "-users", "-audit", "-tls-cert", "-tls-key"},
+			ValueFlags: map[string]int{
+				"-listen": completeNone, "-users": completeFiles, "-audit": completeFiles, "-tls-cert": completeFiles, "-tls-key": completeFiles,
+			},
+			Actions: []string{"token"},
+		},
+		{
+			Name:        "doctor",
+			Description: "check the configuration and the connectivity",
+			Flags:       []string{"-bundle"},
+			ValueFlags:  map[string]int{"-bundle": completeFiles},
+		},
+		{
+			Name:        "completion",
+			Description: "print the shell completion script",
+			Actions:     []string{"bash", "zsh", "fish"},
+		},
+	}
+)
+
+type completionCache struct {
+	JobsTime time.Time                `json:"jobs_time"`
+	Jobs     []listingJobsResponseJob `json:"jobs"`
+}
+
+func getCompletionCachePath() string {
+	return filepath.Join(getConfigDir(), CacheDirName, currentProfileName(), CompletionCacheFileName)
+}
+
+func loadCompletionCache() *completionCache {
+	var c completionCache
+	b, err := ioutil.ReadFile(getCompletionCachePath())
+	if err == nil {
+		json.Unmarshal(b, &c)
+	}
+	return &c
+}
+
+func (c *completionCache) save() {
+	path := getCompletionCachePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return
+	}
+	ioutil.WriteFile(path, b, 0600)
+}
+
+func (c *completionCache) bots(ctx context.Context) []listingBotsResponseBot {
+	ret, _, err := cachedFetchBots(ctx)
+	if err != nil {
+		return nil
+	}
+	return ret.Bots
+}
+
+func (c *completionCache) jobs(ctx context.Context) []listingJobsResponseJob {
+	if time.Since(c.JobsTime) < CompletionCacheTTL {
+		return c.Jobs
+	}
+
+	var jobs []listingJobsResponseJob
+	for _, b := range c.bots(ctx) {
+		ret, _, err := fetchJobs(ctx, b.Id)
+		if err != nil {
+			return c.Jobs
+		}
+		jobs = append(jobs, ret.Jobs...)
+	}
+
+	// running jobs first, then the newest
+	sort.SliceStable(jobs, func(i, j int) bool {
+		ri := jobs[i].Status == JobStatusRunning
+		rj := jobs[j].Status == JobStatusRunning
+		if ri != rj {
+			return ri
+		}
+		return jobs[i].StartTime > jobs[j].StartTime
+	})
+
+	c.Jobs = jobs
+	c.JobsTime = time.Now()
+	c.save()
+	return c.Jobs
+}
+
+func findCompletionCommand(name string) *completionSpec {
+	for _, c := range subCommandCompletions {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// completeCommand prints the candidates for the last word of words.
+// Each candidate is printed as "value\tdescription".
+func completeCommand(words []string) {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	current := words[len(words)-1]
+	words = words[:len(words)-1]
+
+	cmd := topLevelCompletion
+	isTopLevel := true
+	if len(words) > 0 {
+		if c := findCompletionCommand(words[0]); c != nil {
+			cmd = c
+			isTopLevel = false
+			words = words[1:]
+		}
+	}
+
+	// count positional arguments before the current word
+	positional := 0
+	for i := 0; i < len(words); i++ {
+		if strings.HasPrefix(words[i], "-") {
+			if _, ok := cmd.ValueFlags[words[i]]; ok {
+				i++
+			}
+			continue
+		}
+		positional++
+	}
+
+	if len(words) > 0 {
+		if kind, ok := cmd.ValueFlags[words[len(words)-1]]; ok {
+			printCandidates(kind, current)
+			return
+		}
+	}
+
+	if strings.HasPrefix(current, "-") {
+		for _, f := range cmd.Flags {
+			if strings.HasPrefix(f, current) {
+				fmt.Println(f)
+			}
+		}
+		return
+	}
+
+	if isTopLevel {
+		if positional == 0 {
+			for _, c := range subCommandCompletions {
+				if strings.HasPrefix(c.Name, current) {
+					fmt.Printf("%s\t%s\n", c.Name, c.Description)
+				}
+			}
+			for _, p := range listPlugins() {
+				if strings.HasPrefix(p.Name, current) {
+					fmt.Printf("%s\tplugin %s\n", p.Name, p.Path)
+				}
+			}
+			printCandidates(completeBots, current)
+		}
+		return
+	}
+
+	if len(cmd.Actions) > 0 {
+		if positional == 0 {
+			for _, a := range cmd.Actions {
+				if strings.HasPrefix(a, current) {
+					fmt.Println(a)
+				}
+			}
+		}
+		return
+	}
+
+	if positional == 0 {
+		printCandidates(cmd.Args, current)
+	}
+}
+
+func printCandidates(kind int, prefix string) {
+	ctx, cancel := context.WithTimeout(commandContext(), CompletionTimeout)
+	defer cancel()
+
+	switch kind {
+	case completeFiles:
+		fmt.Println(CompletionFileDirective)
+	case completeFormats:
+		for _, f := range []string{"json", "text", "csv"} {
+			if strings.HasPrefix(f, prefix) {
+				fmt.Println(f)
+			}
+		}
+	case completeBots:
+		if UserConfig == nil {
+			return
+		}
+		for alias, id := range UserConfig.Aliases {
+			if strings.HasPrefix(alias, prefix) {
+				fmt.Printf("%s\talias of %s\n", alias, id)
+			}
+		}
+		for _, b := range loadCompletionCache().bots(ctx) {
+			if strings.HasPrefix(b.Id, prefix) {
+				fmt.Printf("%s\t%s\n", b.Id, b.Name)
+			}
+		}
+	case completeJobs:
+		if UserConfig == nil {
+			return
+		}
+		for _, j := range loadCompletionCache().jobs(ctx) {
+			if strings.HasPrefix(j.JobId, prefix) {
+				fmt.Printf("%s\t%s %s %s\n", j.JobId, j.BotName, j.StatusString(), j.StartTime)
+			}
+		}
+	}
+}
+
+// hiddenCompleteCommand is called from the completion scripts.
+// The configuration is loaded without prompting. Bots are completed
+// from the catalog cache and jobs from a short-lived cache.
+func hiddenCompleteCommand(args []string) {
+	// main stops parsing the global options at __complete. The last
+	// word is the one being completed.
+	if len(args) > 0 {
+		args = append(parseGlobalOptions(args[:len(args)-1]), args[len(args)-1])
+	}
+	if config, err := getConfig(); err == nil {
+		UserConfig = config
+	}
+	completeCommand(args)
+}
+
+func completionCommand(args []string) {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, `Usage: cbot-cli completion bash | zsh | fish
+  Print the shell completion script.
+    bash : add 'source <(cbot-cli completion bash)' to ~/.bashrc
+    zsh  : add 'source <(cbot-cli completion zsh)' to ~/.zshrc
+    fish : cbot-cli completion fish > ~/.config/fish/completions/cbot-cli.fish
+`)
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "bash":
+		os.Stdout.WriteString(bashCompletionScript)
+	case "zsh":
+		os.Stdout.WriteString(zshCompletionScript)
+	case "fish":
+		os.Stdout.WriteString(fishCompletionScript)
+	default:
+		fmt.Fprintf(os.Stderr, "unsupported shell '%s'.", args[0])
+		os.Exit(1)
+	}
+}
+
+const bashCompletionScript = `# bash completion for cbot-cli
+_cbot_cli() {
+    local cur="${COMP_WORDS[COMP_CWORD]}"
+    local out
+    out=$(cbot-cli __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null)
+    if [ "$out" = ":file" ]; then
+        COMPREPLY=($(compgen -f -- "$cur"))
+        return
+    fi
+    COMPREPLY=($(compgen -W "$(printf '%s\n' "$out" | cut -f1)" -- "$cur"))
+}
+complete -o default -F _cbot_cli cbot-cli
+`
+
+const zshCompletionScript = `#compdef cbot-cli
+# zsh completion for cbot-cli
+_cbot_cli() {
+    local -a candidates
+    local out line
+    out=$(cbot-cli __complete "${(@)words[2,CURRENT]}" 2>/dev/null)
+    if [ "$out" = ":file" ]; then
+        _files
+        return
+    fi
+    for line in ${(f)out}; do
+        if [[ "$line" == *$'\t'* ]]; then
+            candidates+=("${line%%$'\t'*}:${line#*$'\t'}")
+        else
+            candidates+=("$line")
+        fi
+    done
+    _describe 'cbot-cli' candidates
+}
+if [ "$funcstack[1]" = "_cbot_cli" ]; then
+    _cbot_cli "$@"
+else
+    compdef _cbot_cli cbot-cli
+fi
+`
+
+const fishCompletionScript = `# fish completion for cbot-cli
+function __cbot_cli_complete
+    set -l words (commandline -opc)
+    set -e words[1]
+    set -l out (cbot-cli __complete $words (commandline -ct) 2>/dev/null)
+    if test "$out" = ":file"
+        __fish_complete_path (commandline -ct)
+        return
+    end
+    printf '%s\n' $out
+end
+complete -c cbot-cli -f -a '(__cbot_cli_complete)'
+`
@@ -1,45 +1,225 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
 )
 
 const (
-	ConfigFileName       = "cbot.json"
-	ConfigDirNameWindows = "cbot"
-	ConfigDirNamePosix   = ".cbot"
+	DefaultProfileName     = "default"
+	DefaultContentLanguage = "ja"
+	ProfileEnvName         = "CBOT_PROFILE"
+	ProfilesKey            = "Profiles"
+
+	ConfigFileMode         = 0600
+	ConfigBackupDirName    = "backups"
+	ConfigBackupTimeFormat = "20060102-150405.000000"
+	MaxConfigBackups       = 10
+
+	ConfigFileName = "settings.json"
+	ConfigDirName  = "cbot"
+	ConfigEnvName  = "CBOT_CONFIG"
+
+	LegacyConfigFileName     = "cbot.json"
+	LegacyConfigDirNamePosix = ".cbot"
 )
 
 var (
+	// set by the global --config and --profile options
+	ConfigPathOverride  string
+	ProfileNameOverride string
+
 	ConfigFileNotFoundError = errors.New("config file not found")
+	ProfileNotFoundError    = errors.New("profile not found")
+	SetupCanceledError      = errors.New("setup canceled")
+
+	// profile names are used in the paths of the caches and the states
+	profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
 )
 
 type Config struct {
-	AccessToken     string `json:"AccessToken"`
-	SecretKey       string `json:"SecretKey"`
-	ApiPath         string `json:"ApiPath"`
-	ContentLanguage string `json:"ContentLanguage"`
+	AccessToken     string            `json:"AccessToken"`
+	SecretKey       string            `json:"SecretKey"`
+	ApiPath         string            `json:"ApiPath"`
+	ContentLanguage string            `json:"ContentLanguage"`
+	Aliases         map[string]string `json:"Aliases,omitempty"`
+
+	// protections of the profile (see guard.go)
+	ReadOnly         bool     `json:"ReadOnly,omitempty"`
+	AllowedBots      []string `json:"AllowedBots,omitempty"`
+	DeniedBots       []string `json:"DeniedBots,omitempty"`
+	ConfirmMutations bool     `json:"ConfirmMutations,omitempty"`
+	NoBulk           bool     `json:"NoBulk,omitempty"`
 }
 
-func getConfigDir() string {
+// getStandardConfigDir returns %APPDATA%/cbot on windows and
+// $XDG_CONFIG_HOME/cbot(default ~/.config/cbot) on others.
+func getStandardConfigDir() string {
 	if runtime.GOOS == "windows" {
-		dir := os.Getenv("APPDATA")
-		return filepath.Join(dir, ConfigDirNameWindows)
-	} else {
-		dir := os.Getenv("HOME")
-		return filepath.Join(dir, ConfigDirNamePosix)
+		return filepath.Join(os.Getenv("APPDATA"), ConfigDirName)
+	}
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, ConfigDirName)
+	}
+	return filepath.Join(os.Getenv("HOME"), ".config", ConfigDirName)
+}
+
+func getLegacyConfigDir() string {
+	if runtime.GOOS == "windows" {
+		return getStandardConfigDir()
+	}
+	return filepath.Join(os.Getenv("HOME"), LegacyConfigDirNamePosix)
+}
+
+// getConfigDir returns the directory of the state files like history
+// and caches. The legacy directory is used while it is not migrated.
+func getConfigDir() string {
+	dir := getStandardConfigDir()
+	if isFile(filepath.Join(dir, ConfigFileName)) {
+		return dir
+	}
+	if legacy := getLegacyConfigDir(); legacy != dir {
+		if isFile(filepath.Join(legacy, LegacyConfigFileName)) {
+			return legacy
+		}
+	}
+	return dir
+}
+
+// useProfile switches the API requests and the caches to the profile.
+// The returned function switches them back.
+func useProfile(name string) (func(), error) {
+	config, err := getProfileConfig(name)
+	if err == ProfileNotFoundError {
+		return nil, fmt.Errorf("profile '%s' is not found.", name)
+	} else if err != nil {
+		return nil, err
+	}
+
+	prevConfig, prevName := UserConfig, ProfileNameOverride
+	UserConfig, ProfileNameOverride = config, name
+	return func() {
+		UserConfig, ProfileNameOverride = prevConfig, prevName
+	}, nil
+}
+
+func validateProfileName(name string) error {
+	if !profileNamePattern.MatchString(name) {
+		return fmt.Errorf("invalidate profile name '%s'. Use letters, digits, '.', '_' and '-'.", name)
 	}
+	return nil
 }
 
+func currentProfileName() string {
+	if ProfileNameOverride != "" {
+		return ProfileNameOverride
+	}
+	if name := os.Getenv(ProfileEnvName); name != "" {
+		return name
+	}
+	return DefaultProfileName
+}
+
+// getConfigPath returns the config file in order of the --config
+// option, CBOT_CONFIG and the config directory.
 func getConfigPath() string {
-	return filepath.Join(getConfigDir(), ConfigFileName)
+	if ConfigPathOverride != "" {
+		return ConfigPathOverride
+	}
+	if path := os.Getenv(ConfigEnvName); path != "" {
+		return path
+	}
+
+	dir := getConfigDir()
+	path := filepath.Join(dir, ConfigFileName)
+	if ok, _ := isExist(path); !ok {
+		if legacy := filepath.Join(dir, LegacyConfigFileName); isFile(legacy) {
+			return legacy
+		}
+	}
+	return path
+}
+
+func isFile(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// migrateLegacyConfig moves ~/.cbot to the config directory and renames
+// cbot.json to settings.json. Failures leave the legacy files in use.
+func migrateLegacyConfig() {
+	if ConfigPathOverride != "" || os.Getenv(ConfigEnvName) != "" {
+		return
+	}
+
+	dir := getStandardConfigDir()
+	legacyDir := getLegacyConfigDir()
+	if !isFile(filepath.Join(legacyDir, LegacyConfigFileName)) {
+		return
+	}
+
+	if legacyDir != dir {
+		if isFile(filepath.Join(dir, ConfigFileName)) {
+			// the config directory is already in use.
+			return
+		}
+		if ok, _ := isExist(dir); ok {
+			// created by others (ex: the caches). move the files in.
+			if err := mergeLegacyConfigDir(legacyDir, dir); err != nil {
+				fmt.Fprintf(os.Stderr, "config directory migration failed. %s is used. %v\n", legacyDir, err)
+				return
+			}
+		} else {
+			if err := os.MkdirAll(filepath.Dir(dir), 0700); err != nil {
+				return
+			}
+			if err := os.Rename(legacyDir, dir); err != nil {
+				fmt.Fprintf(os.Stderr, "config directory migration failed. %s is used. %v\n", legacyDir, err)
+				return
+			}
+		}
+	}
+
+	if isFile(filepath.Join(dir, ConfigFileName)) {
+		return
+	}
+	if err := os.Rename(filepath.Join(dir, LegacyConfigFileName), filepath.Join(dir, ConfigFileName)); err != nil {
+		fmt.Fprintf(os.Stderr, "config file migration failed. %v\n", err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "config file is migrated to %s\n", filepath.Join(dir, ConfigFileName))
+}
+
+// mergeLegacyConfigDir moves the files of the legacy directory which do
+// not exist in dir. The legacy directory is removed when emptied.
+func mergeLegacyConfigDir(legacyDir string, dir string) error {
+	files, err := ioutil.ReadDir(legacyDir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		to := filepath.Join(dir, fi.Name())
+		if ok, _ := isExist(to); ok {
+			continue
+		}
+		if err := os.Rename(filepath.Join(legacyDir, fi.Name()), to); err != nil {
+			return err
+		}
+	}
+	os.Remove(legacyDir)
+	return nil
 }
 
 func isExist(path string) (bool, error) {
@@ -51,7 +231,184 @@ func isExist(path string) (bool, error) {
 	return true, nil
 }
 
+// rawConfig is the config file as it is, so that the fields unknown
+// to this version are preserved on save.
+type rawConfig map[string]json.RawMessage
+
+func loadRawConfig(path string) (rawConfig, error) {
+	raw := make(rawConfig)
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return raw, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("config file load failed. %v: %v", path, err)
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("config file load failed. %v: %v", path, err)
+	}
+	if raw == nil {
+		raw = make(rawConfig)
+	}
+	return raw, nil
+}
+
+func (raw rawConfig) profiles() (map[string]rawConfig, error) {
+	profiles := make(map[string]rawConfig)
+	if b, ok := raw[ProfilesKey]; ok {
+		if err := json.Unmarshal(b, &profiles); err != nil {
+			return nil, fmt.Errorf("config file has invalid %s. %v", ProfilesKey, err)
+		}
+	}
+	return profiles, nil
+}
+
+// profile returns the fields of the profile. The default profile is
+// the top level of the file. ok is false when the profile is not found.
+func (raw rawConfig) profile(name string) (rawConfig, bool, error) {
+	if name == DefaultProfileName {
+		p := make(rawConfig)
+		for k, v := range raw {
+			if k != ProfilesKey {
+				p[k] = v
+			}
+		}
+		return p, len(p) > 0, nil
+	}
+
+	profiles, err := raw.profiles()
+	if err != nil {
+		return nil, false, err
+	}
+	p, ok := profiles[name]
+	if p == nil {
+		p = make(rawConfig)
+	}
+	return p, ok, nil
+}
+
+func (raw rawConfig) setProfile(name string, p rawConfig) error {
+	if name == DefaultProfileName {
+		for k := range raw {
+			if k != ProfilesKey {
+				delete(raw, k)
+			}
+		}
+		for k, v := range p {
+			raw[k] = v
+		}
+		return nil
+	}
+
+	profiles, err := raw.profiles()
+	if err != nil {
+		return err
+	}
+	profiles[name] = p
+	b, err := json.Marshal(profiles)
+	if err != nil {
+		return err
+	}
+	raw[ProfilesKey] = b
+	return nil
+}
+
+func (raw rawConfig) write(path string) error {
+	b, err := json.MarshalIndent(raw, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b, ConfigFileMode)
+}
+
+// updateConfig applies fn to the config file under the lock and writes
+// it atomically. The previous file is kept as a backup.
+func updateConfig(fn func(raw rawConfig) error) error {
+	path := getConfigPath()
+
+	lock, err := lockFile(path, DefaultLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	raw, err := loadRawConfig(path)
+	if err != nil {
+		return err
+	}
+	if err := fn(raw); err != nil {
+		return err
+	}
+
+	if err := backupConfig(path); err != nil {
+		return fmt.Errorf("config backup failed. %v", err)
+	}
+	return raw.write(path)
+}
+
+func getConfigBackupDir(path string) string {
+	return filepath.Join(filepath.Dir(path), ConfigBackupDirName)
+}
+
+// listConfigBackups returns the backups of the config file, newest first.
+func listConfigBackups(path string) ([]string, error) {
+	pattern := filepath.Join(getConfigBackupDir(path), filepath.Base(path)+".*")
+	backups, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	return backups, nil
+}
+
+// backupConfig copies the config file to the backup directory and
+// removes the backups over MaxConfigBackups.
+func backupConfig(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	name := filepath.Base(path) + "." + time.Now().Format(ConfigBackupTimeFormat)
+	if err := writeFileAtomic(filepath.Join(getConfigBackupDir(path), name), b, ConfigFileMode); err != nil {
+		return err
+	}
+
+	backups, err := listConfigBackups(path)
+	if err != nil {
+		return err
+	}
+	for i := MaxConfigBackups; i < len(backups); i++ {
+		os.Remove(backups[i])
+	}
+	return nil
+}
+
+// warnConfigPermission warns when the config file is readable by
+// other users. Windows has no such permission bits.
+func warnConfigPermission(path string) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	if perm := fi.Mode().Perm(); perm&0077 != 0 {
+		fmt.Fprintf(os.Stderr, "warning: %s is accessible by other users(%04o). Run chmod 600 %s\n", path, perm, path)
+	}
+}
+
 func getConfig() (*Config, error) {
+	return getProfileConfig(currentProfileName())
+}
+
+// getProfileConfig loads the config of the profile.
+func getProfileConfig(name string) (*Config, error) {
+	if err := validateProfileName(name); err != nil {
+		return nil, err
+	}
 	path := getConfigPath()
 
 	if ok, err := isExist(path); err != nil {
@@ -60,75 +417,229 @@ func getConfig() (*Config, error) {
 		return nil, ConfigFileNotFoundError
 	}
 
-	b, err := ioutil.ReadFile(path)
+	warnConfigPermission(path)
+
+	raw, err := loadRawConfig(path)
 	if err != nil {
-		return nil, fmt.Errorf("config file load failed. %v: %v", path, err)
+		return nil, err
+	}
+	p, ok, err := raw.profile(name)
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ProfileNotFoundError
 	}
 
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
 	var config Config
 	err = json.Unmarshal(b, &config)
 	if err != nil {
 		return nil, fmt.Errorf("config file load failed. %v: %v", path, err)
 	}
-	// TODO
-	config.ContentLanguage = "ja"
+	if config.ContentLanguage == "" {
+		config.ContentLanguage = DefaultContentLanguage
+	}
 	return &config, nil
 }
 
 func createConfigFile() (*Config, error) {
-	path := getConfigPath()
-
-	if err := os.MkdirAll(getConfigDir(), 0700); err != nil {
+	config, err := showConfigSetupPrompt(nil)
+	if err != nil {
 		return nil, err
 	}
 
-	config, err := showConfigSetupPrompt()
-	if err != nil {
+	if err := saveConfig(config); err != nil {
 		return nil, err
 	}
+	return config, nil
+}
 
-	b, err := json.MarshalIndent(config, "", "  ")
+// saveConfig writes the config to the current profile. Fields unknown
+// to Config are kept as they are.
+func saveConfig(config *Config) error {
+	b, err := json.Marshal(config)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	var fields rawConfig
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+
+	return updateConfig(func(raw rawConfig) error {
+		p, _, err := raw.profile(currentProfileName())
+		if err != nil {
+			return err
+		}
+		if len(config.Aliases) == 0 {
+			delete(p, "Aliases")
+		}
+		for k, v := range fields {
+			p[k] = v
+		}
+		return raw.setProfile(currentProfileName(), p)
+	})
+}
+
+// readSetupValue asks the value with the current value as default.
+// Secrets are read without echo when stdin is a terminal.
+func readSetupValue(label string, current string, secret bool) (string, error) {
+	prompt := "Input your " + label
+	if current != "" {
+		shown := current
+		if secret {
+			shown = redact(current)
+		}
+		prompt += " [" + shown + "]"
 	}
+	prompt += ":"
 
-	err = ioutil.WriteFile(path, b, 0700)
+	hidden := secret && isInteractive() && setTerminalEcho(false) == nil
+	var stop func()
+	if hidden {
+		stop = restoreEchoOnInterrupt()
+	}
+	v, err := readPromptLine(prompt)
+	if hidden {
+		stop()
+		setTerminalEcho(true)
+		fmt.Fprintln(os.Stderr)
+	}
 	if err != nil {
-		return nil, err
+		return "", SetupCanceledError
 	}
-	return config, nil
+
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return current, nil
+	}
+	return v, nil
 }
 
-func showConfigSetupPrompt() (*Config, error) {
-	var config Config
-	stdin := bufio.NewScanner(os.Stdin)
+// restoreEchoOnInterrupt turns the echo on again and exits when Ctrl-C
+// is pressed while the echo is off. The returned function stops it.
+func restoreEchoOnInterrupt() func() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-sig:
+			setTerminalEcho(true)
+			fmt.Fprintln(os.Stderr)
+			os.Exit(InterruptExitCode)
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(sig)
+		close(done)
+	}
+}
 
-	fmt.Printf("Input your Access Token:")
-	if !stdin.Scan() {
-		return nil, fmt.Errorf("setup canceled")
+func askYesNo(prompt string, defaultYes bool) bool {
+	if defaultYes {
+		prompt += " [Y/n]:"
+	} else {
+		prompt += " [y/N]:"
 	}
-	config.AccessToken = stdin.Text()
+	v, err := readPromptLine(prompt)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "":
+		return defaultYes
+	case "y", "yes":
+		return true
+	}
+	return false
+}
 
-	fmt.Printf("Input your Secret Key:")
-	if !stdin.Scan() {
-		return nil, fmt.Errorf("setup canceled")
+// testConfig calls the bots endpoint with the config.
+// Forbidden means the credentials are valid without reference authorize.
+func testConfig(ctx context.Context, config *Config) error {
+	saved := UserConfig
+	UserConfig = config
+	defer func() { UserConfig = saved }()
+
+	_, _, err := fetchBots(ctx)
+	if err == ForbiddenError {
+		return nil
 	}
-	config.SecretKey = stdin.Text()
+	return err
+}
 
-	fmt.Printf("Input your API public path:")
-	if !stdin.Scan() {
-		return nil, fmt.Errorf("setup canceled")
+// showConfigSetupPrompt asks the credentials and the API path. The
+// values of current are the defaults. The config is tested before it is
+// returned.
+func showConfigSetupPrompt(current *Config) (*Config, error) {
+	config := Config{ContentLanguage: DefaultContentLanguage}
+	if current != nil {
+		config = *current
 	}
-	config.ApiPath = stdin.Text()
 
-	return &config, nil
+	for {
+		var err error
+		if config.AccessToken, err = readSetupValue("Access Token", config.AccessToken, true); err != nil {
+			return nil, err
+		}
+		if config.SecretKey, err = readSetupValue("Secret Key", config.SecretKey, true); err != nil {
+			return nil, err
+		}
+		for {
+			if config.ApiPath, err = readSetupValue("API public path", config.ApiPath, false); err != nil {
+				return nil, err
+			}
+			err = validateConfigValue("ApiPath", config.ApiPath)
+			if err == nil {
+				break
+			}
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			config.ApiPath = ""
+		}
+
+		fmt.Fprintf(os.Stderr, "checking the credentials...\n")
+		err = testConfig(commandContext(), &config)
+		if err == nil {
+			fmt.Fprintf(os.Stderr, "ok.\n")
+			return &config, nil
+		}
+		if err == UnauthorizedError {
+			fmt.Fprintf(os.Stderr, "unauthorized error returned. Check your access token and key.\n")
+			if askYesNo("Retry?", true) {
+				continue
+			}
+			return nil, SetupCanceledError
+		}
+
+		fmt.Fprintf(os.Stderr, "test call failed. %v\n", err)
+		if askYesNo("Save anyway?", false) {
+			return &config, nil
+		}
+		if !askYesNo("Retry?", true) {
+			return nil, SetupCanceledError
+		}
+	}
 }
 
 func updateConfigFile() (*Config, error) {
-	return createConfigFile()
+	config, err := showConfigSetupPrompt(UserConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := saveConfig(config); err != nil {
+		return nil, err
+	}
+	return config, nil
 }
 
 func displayCurrentConfig() {
+	fmt.Printf("Profile      : %s\n", currentProfileName())
 	fmt.Printf("Access Token : %s\n", UserConfig.AccessToken)
 	fmt.Printf("Secret Key   : %s\n", UserConfig.SecretKey)
 	fmt.Printf("API Path     : %s\n", UserConfig.ApiPath)
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	configKeys = []string{"AccessToken", "SecretKey", "ApiPath", "ContentLanguage",
+		"ReadOnly", "AllowedBots", "DeniedBots", "ConfirmMutations", "NoBulk"}
+
+	secretConfigKeys = map[string]bool{"AccessToken": true, "SecretKey": true}
+
+	// protection keys hold a bool or a comma separated list, not a string
+	boolConfigKeys = map[string]bool{"ReadOnly": true, "ConfirmMutations": true, "NoBulk": true}
+	listConfigKeys = map[string]bool{"AllowedBots": true, "DeniedBots": true}
+
+	languagePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]+)*$`)
+)
+
+// normalizeConfigKey accepts keys case-insensitively and in snake or
+// kebab case. (ex: api_path, api-path, apipath)
+func normalizeConfigKey(key string) (string, error) {
+	k := strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(key))
+	for _, name := range configKeys {
+		if strings.ToLower(name) == k {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("unknown config key '%s'. Keys are %s. Aliases are managed by cbot-cli alias.", key, strings.Join(configKeys, ", "))
+}
+
+func validateConfigValue(key string, value string) error {
+	switch key {
+	case "ApiPath":
+		u, err := url.Parse(value)
+		if err != nil {
+			return fmt.Errorf("ApiPath '%s' is not a URL. %v", value, err)
+		}
+		if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("ApiPath '%s' needs prefix https:// and a host.", value)
+		}
+	case "ContentLanguage":
+		if !languagePattern.MatchString(value) {
+			return fmt.Errorf("ContentLanguage '%s' is not a language tag. Ex: ja, en", value)
+		}
+	case "ReadOnly", "ConfirmMutations", "NoBulk":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("%s must be true or false.", key)
+		}
+	case "AllowedBots", "DeniedBots":
+		if len(splitConfigList(value)) == 0 {
+			return fmt.Errorf("%s must be comma separated bot ids, aliases or names.", key)
+		}
+	default:
+		if value == "" || strings.TrimSpace(value) != value || strings.ContainsAny(value, " \t\r\n") {
+			return fmt.Errorf("%s must not be empty or contain spaces.", key)
+		}
+	}
+	return nil
+}
+
+func splitConfigList(value string) []string {
+	var ret []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+// marshalConfigValue converts the value of config set to the JSON type
+// of the key.
+func marshalConfigValue(key string, value string) ([]byte, error) {
+	switch {
+	case boolConfigKeys[key]:
+		b, _ := strconv.ParseBool(value)
+		return json.Marshal(b)
+	case listConfigKeys[key]:
+		return json.Marshal(splitConfigList(value))
+	}
+	return json.Marshal(value)
+}
+
+func configCommand(args []string) {
+	usage := func() {
+		fmt.Fprint(os.Stderr, `Usage: cbot-cli config list [-reveal]
+       cbot-cli config get KEY
+       cbot-cli config set KEY VALUE
+       cbot-cli config unset KEY
+       cbot-cli config profiles
+       cbot-cli config edit
+       cbot-cli config restore [-l] [BACKUP]
+  Manage the config of the current profile(--profile NAME) without prompts.
+  Keys are AccessToken, SecretKey, ApiPath and ContentLanguage.
+  Protection keys are ReadOnly, ConfirmMutations, NoBulk(true or false)
+  and AllowedBots, DeniedBots(comma separated bot ids, aliases or names).
+  Fields unknown to this version are kept as they are.
+  edit opens the config file with $VISUAL or $EDITOR.
+  restore restores the newest backup or BACKUP(a number or a name of -l).
+  Backups are kept on every change of the config file.
+`)
+		os.Exit(1)
+	}
+	if len(args) < 1 {
+		usage()
+	}
+
+	var err error
+	switch args[0] {
+	case "list":
+		fs := flag.NewFlagSet("config list", flag.ExitOnError)
+		reveal := fs.Bool("reveal", false, "print secrets as they are")
+		fs.Usage = usage
+		fs.Parse(args[1:])
+		err = execConfigList(*reveal)
+	case "get":
+		if len(args) != 2 {
+			usage()
+		}
+		err = execConfigGet(args[1])
+	case "set":
+		if len(args) != 3 {
+			usage()
+		}
+		err = execConfigSet(args[1], &args[2])
+	case "unset":
+		if len(args) != 2 {
+			usage()
+		}
+		err = execConfigSet(args[1], nil)
+	case "profiles":
+		err = execConfigProfiles()
+	case "edit":
+		err = execConfigEdit()
+	case "restore":
+		fs := flag.NewFlagSet("config restore", flag.ExitOnError)
+		list := fs.Bool("l", false, "list backups")
+		fs.Usage = usage
+		fs.Parse(args[1:])
+		err = execConfigRestore(*list, fs.Arg(0))
+	default:
+		usage()
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		os.Exit(1)
+	}
+}
+
+func loadCurrentProfile() (rawConfig, rawConfig, error) {
+	raw, err := loadRawConfig(getConfigPath())
+	if err != nil {
+		return nil, nil, err
+	}
+	p, _, err := raw.profile(currentProfileName())
+	if err != nil {
+		return nil, nil, err
+	}
+	return raw, p, nil
+}
+
+func configValueString(v json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(v, &s); err == nil {
+		return s
+	}
+	return compactOutput(v)
+}
+
+func execConfigList(reveal bool) error {
+	_, p, err := loadCurrentProfile()
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(p))
+	for k := range p {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := configValueString(p[k])
+		if secretConfigKeys[k] && !reveal {
+			v = redact(v)
+		}
+		fmt.Printf("%s=%s\n", k, v)
+	}
+	return nil
+}
+
+func execConfigGet(key string) error {
+	name, err := normalizeConfigKey(key)
+	if err != nil {
+		return err
+	}
+	_, p, err := loadCurrentProfile()
+	if err != nil {
+		return err
+	}
+
+	v, ok := p[name]
+	if !ok {
+		return fmt.Errorf("%s is not set in profile '%s'.", name, currentProfileName())
+	}
+	fmt.Println(configValueString(v))
+	return nil
+}
+
+// execConfigSet sets the value of the key. nil value unsets the key.
+func execConfigSet(key string, value *string) error {
+	name, err := normalizeConfigKey(key)
+	if err != nil {
+		return err
+	}
+	var data []byte
+	if value != nil {
+		if err := validateConfigValue(name, *value); err != nil {
+			return err
+		}
+		if data, err = marshalConfigValue(name, *value); err != nil {
+			return err
+		}
+	}
+
+	return updateConfig(func(raw rawConfig) error {
+		p, _, err := raw.profile(currentProfileName())
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			delete(p, name)
+		} else {
+			p[name] = data
+		}
+		return raw.setProfile(currentProfileName(), p)
+	})
+}
+
+func execConfigProfiles() error {
+	raw, err := loadRawConfig(getConfigPath())
+	if err != nil {
+		return err
+	}
+	profiles, err := raw.profiles()
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	if _, ok, _ := raw.profile(DefaultProfileName); ok {
+		names = append(names, DefaultProfileName)
+	}
+	var others []string
+	for name := range profiles {
+		others = append(others, name)
+	}
+	sort.Strings(others)
+	names = append(names, others...)
+
+	for _, name := range names {
+		mark := " "
+		if name == currentProfileName() {
+			mark = "*"
+		}
+		fmt.Printf("%s %s\n", mark, name)
+	}
+	return nil
+}
+
+func getEditor() string {
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		if e := os.Getenv(env); e != "" {
+			return e
+		}
+	}
+	if runtime.GOOS == "windows" {
+		return "notepad"
+	}
+	return "vi"
+}
+
+// execConfigEdit edits a copy of the config file and replaces the file
+// only when the edited copy is a valid config.
+func execConfigEdit() error {
+	path := getConfigPath()
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		b = []byte("{}\n")
+	} else if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile("", "cbot-config-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(b)
+	tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	editor := strings.Fields(getEditor())
+	cmd := exec.Command(editor[0], append(editor[1:], tmp.Name())...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("editor '%s' failed. %v", strings.Join(editor, " "), err)
+	}
+
+	edited, err := loadRawConfig(tmp.Name())
+	if err != nil {
+		return errors.New("edited config is not saved. " + err.Error())
+	}
+	if _, err := edited.profiles(); err != nil {
+		return errors.New("edited config is not saved. " + err.Error())
+	}
+	return updateConfig(func(raw rawConfig) error {
+		replaceRawConfig(raw, edited)
+		return nil
+	})
+}
+
+func replaceRawConfig(raw rawConfig, src rawConfig) {
+	for k := range raw {
+		delete(raw, k)
+	}
+	for k, v := range src {
+		raw[k] = v
+	}
+}
+
+// execConfigRestore restores the backup. The current file is backed up
+// before, so that the restore can be undone.
+func execConfigRestore(list bool, name string) error {
+	path := getConfigPath()
+	backups, err := listConfigBackups(path)
+	if err != nil {
+		return err
+	}
+
+	if list {
+		for i, b := range backups {
+			fmt.Printf("%d\t%s\n", i+1, filepath.Base(b))
+		}
+		return nil
+	}
+	if len(backups) == 0 {
+		return fmt.Errorf("no backups of %s are found.", path)
+	}
+
+	backup := backups[0]
+	if name != "" {
+		backup = ""
+		if n, err := strconv.Atoi(name); err == nil && n >= 1 && n <= len(backups) {
+			backup = backups[n-1]
+		}
+		for _, b := range backups {
+			if filepath.Base(b) == name {
+				backup = b
+			}
+		}
+		if backup == "" {
+			return fmt.Errorf("backup '%s' is not found. See cbot-cli config restore -l", name)
+		}
+	}
+
+	restored, err := loadRawConfig(backup)
+	if err != nil {
+		return err
+	}
+	if err := updateConfig(func(raw rawConfig) error {
+		replaceRawConfig(raw, restored)
+		return nil
+	}); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%s is restored from %s\n", path, filepath.Base(backup))
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+var (
+	// set by the global --timeout option
+	CommandTimeout time.Duration
+
+	commandCtx    = context.Background()
+	commandCancel context.CancelFunc
+)
+
+// startCommandContext bounds the whole command by --timeout.
+func startCommandContext() {
+	if CommandTimeout > 0 {
+		commandCtx, commandCancel = context.WithTimeout(context.Background(), CommandTimeout)
+	}
+}
+
+// commandContext is the context of the API requests of the command.
+func commandContext() context.Context {
+	return commandCtx
+}
+
+// requestContext bounds a unit of work of the long running commands
+// (scheduler, exporter and proxy) by --timeout, since they are not
+// bounded as a whole.
+func requestContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if CommandTimeout > 0 {
+		return context.WithTimeout(parent, CommandTimeout)
+	}
+	return context.WithCancel(parent)
+}
+
+// sleepContext sleeps d, or returns the error of ctx when it is done
+// before.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// monday
+	base := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	date := func(month time.Month, day int, hour int, min int) time.Time {
+		return time.Date(2024, month, day, hour, min, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		// minute
+		{"* * * * *", base, date(1, 15, 10, 31)},
+		{"45 * * * *", base, date(1, 15, 10, 45)},
+		{"0 * * * *", base, date(1, 15, 11, 0)},
+		{"*/15 * * * *", base, date(1, 15, 10, 45)},
+		{"5/20 * * * *", base, date(1, 15, 10, 45)},
+		{"10-20 * * * *", base, date(1, 15, 11, 10)},
+		{"5,40 * * * *", base, date(1, 15, 10, 40)},
+		// hour
+		{"0 9-17/4 * * *", base, date(1, 15, 13, 0)},
+		{"30 10 * * *", base, date(1, 16, 10, 30)},
+		{"30 10 * * *", base.Add(-time.Second), date(1, 15, 10, 30)},
+		// day of month and month
+		{"0 0 1 * *", base, date(2, 1, 0, 0)},
+		{"0 0 * mar *", base, date(3, 1, 0, 0)},
+		{"0 0 1 jan-feb *", base, date(2, 1, 0, 0)},
+		{"0 0 29 2 *", base, date(2, 29, 0, 0)},
+		{"0 0 29 2 *", date(3, 1, 0, 0), time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", base, time.Time{}},
+		// day of week
+		{"0 0 * * fri", base, date(1, 19, 0, 0)},
+		{"0 0 * * 7", base, date(1, 21, 0, 0)},
+		{"0 0 * * 0", base, date(1, 21, 0, 0)},
+		{"0 0 * * mon-wed", base, date(1, 16, 0, 0)},
+		{"0 0 * * 1,3,5", base, date(1, 17, 0, 0)},
+		// either of day of month and day of week matches
+		{"0 0 20 * fri", base, date(1, 19, 0, 0)},
+		{"0 0 16 * sun", base, date(1, 16, 0, 0)},
+		{"0 0 13 * fri", base, date(1, 19, 0, 0)},
+		// macros
+		{"@hourly", base, date(1, 15, 11, 0)},
+		{"@daily", base, date(1, 16, 0, 0)},
+		{"@weekly", base, date(1, 21, 0, 0)},
+		{"@monthly", base, date(2, 1, 0, 0)},
+		{"@yearly", base, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		s, err := parseCron(tt.expr)
+		if err != nil {
+			t.Errorf("parseCron(%q) failed. %v", tt.expr, err)
+			continue
+		}
+		if got := s.Next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("%q after %v: got %v, want %v", tt.expr, tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestCronParseError(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"0 0 0 * *",
+		"0 0 * 13 *",
+		"0 0 * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"0 0 * foo *",
+		"0 0 * * 1-",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) is accepted", expr)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	DoctorStatusOK   = "ok"
+	DoctorStatusWarn = "warn"
+	DoctorStatusFail = "fail"
+	DoctorStatusSkip = "skip"
+
+	DoctorDialTimeout = 10 * time.Second
+)
+
+type doctorCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+}
+
+type doctorReport struct {
+	Time   time.Time         `json:"time"`
+	OS     string            `json:"os"`
+	Arch   string            `json:"arch"`
+	Go     string            `json:"go"`
+	Config map[string]string `json:"config"`
+	Checks []doctorCheck     `json:"checks"`
+}
+
+func (r *doctorReport) add(name string, status string, format string, a ...interface{}) {
+	r.Checks = append(r.Checks, doctorCheck{Name: name, Status: status, Detail: fmt.Sprintf(format, a...)})
+}
+
+func (r *doctorReport) failed() bool {
+	for _, c := range r.Checks {
+		if c.Status == DoctorStatusFail {
+			return true
+		}
+	}
+	return false
+}
+
+// redact keeps the first characters of the secret so that the user can
+// tell which credential is configured.
+func redact(s string) string {
+	if s == "" {
+		return ""
+	}
+	if len(s) <= 8 {
+		return strings.Repeat("*", len(s))
+	}
+	return s[:4] + strings.Repeat("*", len(s)-4)
+}
+
+// maskSecret hides the whole secret. It is used for the report, which
+// may be shared as the diagnostics bundle.
+func maskSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "********"
+}
+
+func doctorCommand(args []string) {
+	var bundlePath string
+
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	fs.StringVar(&bundlePath, "bundle", "", "diagnostics bundle file")
+
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, `Usage: cbot-cli doctor [OPTION]...
+  Check the configuration and the connectivity to the API.
+  Options:
+    -bundle FILE   : write the diagnostics with redacted credentials to FILE.
+`)
+	}
+	fs.Parse(args)
+
+	report := execDoctor(commandContext())
+	for _, c := range report.Checks {
+		fmt.Printf("[%-4s] %-12s %s\n", c.Status, c.Name, c.Detail)
+	}
+
+	if bundlePath != "" {
+		b, err := json.MarshalIndent(report, "", "  ")
+		if err == nil {
+			err = ioutil.WriteFile(bundlePath, b, 0600)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "diagnostics bundle write failed. %v", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "diagnostics bundle is written to %s\n", bundlePath)
+	}
+
+	if report.failed() {
+		os.Exit(1)
+	}
+}
+
+func execDoctor(ctx context.Context) *doctorReport {
+	report := &doctorReport{
+		Time: time.Now(),
+		OS:   runtime.GOOS,
+		Arch: runtime.GOARCH,
+		Go:   runtime.Version(),
+	}
+
+	config := doctorCheckConfig(report)
+	if config == nil {
+		return report
+	}
+	report.Config = map[string]string{
+		"AccessToken":     maskSecret(config.AccessToken),
+		"SecretKey":       maskSecret(config.SecretKey),
+		"ApiPath":         config.ApiPath,
+		"ContentLanguage": config.ContentLanguage,
+	}
+
+	u := doctorCheckApiPath(report, config)
+	if u == nil {
+		return report
+	}
+	if !doctorCheckNetwork(ctx, report, u) {
+		return report
+	}
+
+	UserConfig = config
+	doctorCheckAPI(ctx, report)
+	return report
+}
+
+func doctorCheckConfig(report *doctorReport) *Config {
+	path := getConfigPath()
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		report.add("config file", DoctorStatusFail, "%s is not found. Run cbot-cli -r to create it.", path)
+		return nil
+	} else if err != nil {
+		report.add("config file", DoctorStatusFail, "%v", err)
+		return nil
+	}
+	report.add("config file", DoctorStatusOK, "%s", path)
+
+	if runtime.GOOS != "windows" {
+		if perm := fi.Mode().Perm(); perm&0077 != 0 {
+			report.add("file mode", DoctorStatusWarn, "%s is readable by other users(%04o). Run chmod 600 %s", path, perm, path)
+		} else {
+			report.add("file mode", DoctorStatusOK, "%04o", perm)
+		}
+	}
+
+	config, err := getConfig()
+	if err != nil {
+		report.add("config", DoctorStatusFail, "%v", err)
+		return nil
+	}
+
+	if config.AccessToken == "" || config.SecretKey == "" {
+		report.add("credentials", DoctorStatusFail, "access token or secret key is empty. Run cbot-cli -r")
+	} else {
+		report.add("credentials", DoctorStatusOK, "access token %s", maskSecret(config.AccessToken))
+	}
+	return config
+}
+
+func doctorCheckApiPath(report *doctorReport, config *Config) *url.URL {
+	u, err := url.Parse(config.ApiPath)
+	if err != nil {
+		report.add("api path", DoctorStatusFail, "'%s' is not a URL. %v", config.ApiPath, err)
+		return nil
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		report.add("api path", DoctorStatusFail, "'%s' needs prefix https://", config.ApiPath)
+		return nil
+	}
+	if u.Host == "" {
+		report.add("api path", DoctorStatusFail, "'%s' has no host.", config.ApiPath)
+		return nil
+	}
+
+	p := strings.TrimSuffix(u.Path, "/")
+	switch {
+	case strings.HasSuffix(p, "/bots") || strings.Contains(p, "/bots/"):
+		report.add("api path", DoctorStatusWarn, "'%s' should be the API public path without /bots.", config.ApiPath)
+	case p == "":
+		report.add("api path", DoctorStatusWarn, "'%s' has no path. The API public path usually has one.", config.ApiPath)
+	case u.Scheme == "http":
+		report.add("api path", DoctorStatusWarn, "'%s' is not https. Credentials are sent in plain text.", config.ApiPath)
+	default:
+		report.add("api path", DoctorStatusOK, "%s", config.ApiPath)
+	}
+	return u
+}
+
+func doctorCheckNetwork(ctx context.Context, report *doctorReport, u *url.URL) bool {
+	host := u.Hostname()
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, DoctorDialTimeout)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		report.add("dns", DoctorStatusFail, "%v", err)
+		return false
+	}
+	report.add("dns", DoctorStatusOK, "%s -> %s", host, strings.Join(addrs, ", "))
+
+	addr := net.JoinHostPort(host, port)
+	if u.Scheme != "https" {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			report.add("connect", DoctorStatusFail, "%v", err)
+			return false
+		}
+		conn.Close()
+		report.add("connect", DoctorStatusOK, "%s", addr)
+		report.add("tls", DoctorStatusSkip, "not https")
+		return true
+	}
+
+	dialer := &tls.Dialer{Config: &tls.Config{ServerName: host}}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		report.add("tls", DoctorStatusFail, "%v", err)
+		return false
+	}
+	defer conn.Close()
+
+	state := conn.(*tls.Conn).ConnectionState()
+	cert := state.PeerCertificates[0]
+	status := DoctorStatusOK
+	if time.Until(cert.NotAfter) < 14*24*time.Hour {
+		status = DoctorStatusWarn
+	}
+	report.add("tls", status, "%s, certificate for %s expires %s", tlsVersionString(state.Version), cert.Subject.CommonName, cert.NotAfter.Format("2006-01-02"))
+	return true
+}
+
+func tlsVersionString(v uint16) string {
+	switch v {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("TLS 0x%04x", v)
+}
+
+// doctorCheckAPI calls the API with the credentials. The execute
+// permission is not checked because it would run a bot.
+func doctorCheckAPI(ctx context.Context, report *doctorReport) {
+	bots, _, err := fetchBots(ctx)
+	switch {
+	case err == UnauthorizedError:
+		report.add("auth", DoctorStatusFail, "unauthorized. Check your access token and key.")
+		return
+	case err == ForbiddenError:
+		report.add("auth", DoctorStatusOK, "credentials are accepted")
+		report.add("permissions", DoctorStatusWarn, "bot reference: no. Bot names and listings are unavailable.")
+		return
+	case err != nil:
+		if _, ok := err.(*json.SyntaxError); ok {
+			report.add("auth", DoctorStatusFail, "the API path does not answer JSON. Check the API public path. %v", err)
+			return
+		}
+		report.add("auth", DoctorStatusFail, "listing bots failed. %v", err)
+		return
+	}
+	report.add("auth", DoctorStatusOK, "credentials are accepted")
+	report.add("permissions", DoctorStatusOK, "bot reference: yes (%d bots)", len(bots.Bots))
+
+	if len(bots.Bots) == 0 {
+		report.add("permissions", DoctorStatusSkip, "job reference: no bots to check")
+		return
+	}
+	_, _, err = fetchJobs(ctx, bots.Bots[0].Id)
+	if err == ForbiddenError {
+		report.add("permissions", DoctorStatusWarn, "job reference: no")
+	} else if err != nil {
+		report.add("permissions", DoctorStatusWarn, "job reference: unknown. %v", err)
+	} else {
+		report.add("permissions", DoctorStatusOK, "job reference: yes")
+	}
+	report.add("permissions", DoctorStatusSkip, "bot execute: not checked since it runs a bot")
+}
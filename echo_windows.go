@@ -0,0 +1,14 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"errors"
+)
+
+// setTerminalEcho is not supported on windows without console APIs.
+// Secrets are read with echo.
+func setTerminalEcho(on bool) error {
+	return errors.New("terminal echo control is not supported")
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -30,6 +31,13 @@ type execBotResponse struct {
 }
 
 func setupParameter(param *execParameter) error {
+	if param.execInputParam == "" {
+		// input may be prompted already
+		if param.Input == nil {
+			param.Input = make(map[string]string)
+		}
+		return nil
+	}
 	param.Input = make(map[string]string)
 	pairs := strings.Split(param.execInputParam, ",")
 
@@ -49,7 +57,16 @@ func execBotPortal(botId string, param execParameter) {
 		fmt.Fprintf(os.Stderr, "parameter format is invalidate. Ex: key:value")
 		os.Exit(1)
 	}
-	err = execBot(botId, param)
+	ctx := commandContext()
+	if err := validateInput(ctx, botId, param.Input); err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		os.Exit(1)
+	}
+	err = execBot(ctx, botId, param)
+	exitOnExecBotError(botId, err)
+}
+
+func exitOnExecBotError(botId string, err error) {
 	if err == UnauthorizedError {
 		fmt.Fprintf(os.Stderr, "unauthorized error returned. Check your access token and key.")
 		os.Exit(1)
@@ -82,7 +99,7 @@ func buildExecBotURL(botId string) (string, error) {
 	return u.String(), nil
 }
 
-func buildExecBotRequest(botId string, param execParameter) (*http.Request, error) {
+func buildExecBotRequest(ctx context.Context, botId string, param execParameter) (*http.Request, error) {
 	url, err := buildExecBotURL(botId)
 	if err != nil {
 		return nil, err
@@ -93,7 +110,7 @@ func buildExecBotRequest(botId string, param execParameter) (*http.Request, erro
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(p))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(p))
 	if err != nil {
 		return nil, err
 	}
@@ -106,16 +123,16 @@ func buildExecBotRequest(botId string, param execParameter) (*http.Request, erro
 	return req, nil
 }
 
-func processExecBotResponse(resp *http.Response) error {
+func processExecBotResponse(resp *http.Response) (*execBotResponse, []byte, error) {
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	var ret execBotResponse
 	err = json.Unmarshal(body, &ret)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	switch ret.Code {
@@ -125,42 +142,66 @@ func processExecBotResponse(resp *http.Response) error {
 		// nothing todo
 		//return BotAlreadyRunningError
 	case 401:
-		return UnauthorizedError
+		return nil, nil, UnauthorizedError
 	case 403:
-		return ForbiddenError
+		return nil, nil, ForbiddenError
 	case 404:
-		return BotNotFoundError
+		return nil, nil, BotNotFoundError
 	case 410:
-		return BotExecutionIsAbortedError
+		return nil, nil, BotExecutionIsAbortedError
 	case 429:
-		return TooManyExecuteRequestError
+		return nil, nil, TooManyExecuteRequestError
 	default:
 		// error
-		return fmt.Errorf("response code '%d' returned.", ret.Code)
+		return nil, nil, &responseCodeError{Code: ret.Code}
 	}
 
-	fmt.Println(string(body))
+	return &ret, body, nil
+}
 
-	return nil
+// runBot submits a job of the bot and returns the decoded response
+// together with the raw response body. The run is recorded in the history.
+func runBot(ctx context.Context, botId string, param execParameter) (*execBotResponse, []byte, error) {
+	if err := guardMutation(ctx, AuditOperationRun, botId, ""); err != nil {
+		recordAudit(AuditOperationRun, botId, "", param.Input, errorCode(err), err)
+		return nil, nil, err
+	}
+
+	ret, body, err := requestExecBot(ctx, botId, param)
+	recordRun(botId, param, ret, err)
+
+	jobId, code := "", errorCode(err)
+	if ret != nil {
+		jobId, code = ret.JobId, ret.Code
+	}
+	recordAudit(AuditOperationRun, botId, jobId, param.Input, code, err)
+	return ret, body, err
 }
 
-func execBot(botId string, param execParameter) error {
+func requestExecBot(ctx context.Context, botId string, param execParameter) (*execBotResponse, []byte, error) {
 	client := http.DefaultClient
 
-	req, err := buildExecBotRequest(botId, param)
+	req, err := buildExecBotRequest(ctx, botId, param)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
 
-	if err := processExecBotResponse(resp); err != nil {
+	return processExecBotResponse(resp)
+}
+
+func execBot(ctx context.Context, botId string, param execParameter) error {
+	_, body, err := runBot(ctx, botId, param)
+	if err != nil {
 		return err
 	}
 
+	fmt.Println(string(body))
+
 	return nil
 }
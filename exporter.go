@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultExporterListen   = ":9876"
+	DefaultExporterInterval = 60 * time.Second
+
+	// metrics are served from memory
+	ExporterReadHeaderTimeout = 10 * time.Second
+	ExporterReadTimeout       = 10 * time.Second
+	ExporterWriteTimeout      = 30 * time.Second
+)
+
+var (
+	// buckets of the elapsed time histogram
+	elapsedTimeBuckets = []float64{10, 30, 60, 120, 300, 600, 1200, 1800, 3600, 7200}
+)
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(elapsedTimeBuckets))
+	}
+	for i, b := range elapsedTimeBuckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+type exporterBot struct {
+	id          string
+	name        string
+	running     int
+	outcomes    map[string]uint64
+	elapsed     histogram
+	lastSuccess time.Time
+	seen        map[string]bool
+}
+
+type exporter struct {
+	botIds []string
+	logger *log.Logger
+
+	mu           sync.Mutex
+	bots         map[string]*exporterBot
+	botCount     int
+	scrapeErrors map[string]uint64
+	lastScrape   time.Time
+	lastSuccess  bool
+}
+
+func exporterCommand(args []string) {
+	var listen string
+	var interval time.Duration
+	var botIds string
+
+	fs := flag.NewFlagSet("exporter", flag.ExitOnError)
+	fs.StringVar(&listen, "listen", DefaultExporterListen, "listen address")
+	fs.DurationVar(&interval, "interval", DefaultExporterInterval, "polling interval")
+	fs.StringVar(&botIds, "b", "", "bot ids")
+
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, `Usage: cbot-cli exporter [OPTION]...
+  Serve Prometheus metrics of the bots and jobs on /metrics.
+  Options:
+    -listen ADDRESS    : listen address.[default ':9876']
+    -interval DURATION : polling interval of Cloud Bot.(ex: 30s, 5m)[default 1m]
+    -b BOT,...         : watch the bots only.[default all bots]
+`)
+	}
+	fs.Parse(args)
+	if err := validateInterval(interval); err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		os.Exit(1)
+	}
+
+	e := &exporter{
+		logger:       log.New(os.Stderr, "", log.LstdFlags),
+		bots:         make(map[string]*exporterBot),
+		scrapeErrors: make(map[string]uint64),
+	}
+	if botIds != "" {
+		for _, ref := range strings.Split(botIds, ",") {
+			e.botIds = append(e.botIds, resolveBotIdPortal(ref))
+		}
+	}
+
+	go e.pollLoop(interval)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", e.serveMetrics)
+	server := &http.Server{
+		Addr:              listen,
+		Handler:           mux,
+		ReadHeaderTimeout: ExporterReadHeaderTimeout,
+		ReadTimeout:       ExporterReadTimeout,
+		WriteTimeout:      ExporterWriteTimeout,
+	}
+
+	e.logger.Printf("serving metrics on %s/metrics", listen)
+	if err := server.ListenAndServe(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		os.Exit(1)
+	}
+}
+
+func scrapeErrorReason(err error) string {
+	switch err {
+	case UnauthorizedError:
+		return "unauthorized"
+	case ForbiddenError:
+		return "forbidden"
+	case BotNotFoundError:
+		return "not_found"
+	default:
+		return "other"
+	}
+}
+
+func (e *exporter) pollLoop(interval time.Duration) {
+	for {
+		e.poll()
+		time.Sleep(interval)
+	}
+}
+
+func (e *exporter) recordScrapeError(endpoint string, err error) {
+	e.logger.Printf("%s polling failed. %v", endpoint, err)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.scrapeErrors[endpoint+"\x00"+scrapeErrorReason(err)]++
+}
+
+// poll updates the metrics. cbot_scrape_success is updated when the
+// polling finishes, so that scrapes in the middle see the last result.
+func (e *exporter) poll() {
+	success := true
+	listed := false
+	defer func() {
+		e.mu.Lock()
+		e.lastScrape = time.Now()
+		e.lastSuccess = success
+		e.mu.Unlock()
+	}()
+
+	type target struct {
+		id   string
+		name string
+	}
+	var targets []target
+
+	ctx, cancel := requestContext(context.Background())
+	defer cancel()
+
+	bots, _, err := fetchBots(ctx)
+	if err != nil {
+		success = false
+		e.recordScrapeError("bots", err)
+		// keep watching the specified bots even if listing is not allowed
+		for _, id := range e.botIds {
+			targets = append(targets, target{id: id})
+		}
+	} else {
+		listed = true
+		e.mu.Lock()
+		e.botCount = len(bots.Bots)
+		e.mu.Unlock()
+
+		watch := make(map[string]bool)
+		for _, id := range e.botIds {
+			watch[id] = true
+		}
+		for _, b := range bots.Bots {
+			if len(watch) == 0 || watch[b.Id] {
+				targets = append(targets, target{id: b.Id, name: b.Name})
+			}
+		}
+	}
+
+	for _, t := range targets {
+		jobs, _, err := fetchJobs(ctx, t.id)
+		if err != nil {
+			success = false
+			e.recordScrapeError("jobs", err)
+			continue
+		}
+		e.updateBot(t.id, t.name, jobs.Jobs)
+	}
+
+	if listed {
+		// drop the series of the bots deleted or no longer watched
+		current := make(map[string]bool)
+		for _, t := range targets {
+			current[t.id] = true
+		}
+		e.mu.Lock()
+		for id := range e.bots {
+			if !current[id] {
+				delete(e.bots, id)
+			}
+		}
+		e.mu.Unlock()
+	}
+}
+
+// updateBot updates the metrics of the bot. Finished jobs are counted
+// once when they are seen for the first time.
+func (e *exporter) updateBot(botId string, botName string, jobs []listingJobsResponseJob) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	b, ok := e.bots[botId]
+	if !ok {
+		b = &exporterBot{
+			id:       botId,
+			outcomes: make(map[string]uint64),
+			seen:     make(map[string]bool),
+		}
+		e.bots[botId] = b
+	}
+	if botName != "" {
+		b.name = botName
+	}
+
+	b.running = 0
+	current := make(map[string]bool)
+	for _, j := range jobs {
+		if b.name == "" {
+			b.name = j.BotName
+		}
+		if j.Status == JobStatusRunning {
+			b.running++
+			continue
+		}
+
+		current[j.JobId] = true
+		if b.seen[j.JobId] {
+			continue
+		}
+		b.outcomes[j.StatusString()]++
+		b.elapsed.observe(float64(j.ElapsedTime))
+
+		if j.Status == JobStatusExit {
+			if t, err := parseJobStartTime(j.StartTime); err == nil && t.After(b.lastSuccess) {
+				b.lastSuccess = t
+			}
+		}
+	}
+	// forget the jobs dropped from the listing
+	b.seen = current
+}
+
+func escapeLabel(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	return strings.Replace(s, `"`, `\"`, -1)
+}
+
+func formatFloat(v float64) string {
+	return fmt.Sprintf("%g", v)
+}
+
+func (e *exporter) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var buf bytes.Buffer
+
+	ids := make([]string, 0, len(e.bots))
+	for id := range e.bots {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	labels := func(b *exporterBot) string {
+		return fmt.Sprintf(`bot_id="%s",bot_name="%s"`, escapeLabel(b.id), escapeLabel(b.name))
+	}
+
+	fmt.Fprintln(&buf, "# HELP cbot_bots Number of bots.")
+	fmt.Fprintln(&buf, "# TYPE cbot_bots gauge")
+	fmt.Fprintf(&buf, "cbot_bots %d\n", e.botCount)
+
+	fmt.Fprintln(&buf, "# HELP cbot_jobs_running Number of running jobs.")
+	fmt.Fprintln(&buf, "# TYPE cbot_jobs_running gauge")
+	for _, id := range ids {
+		b := e.bots[id]
+		fmt.Fprintf(&buf, "cbot_jobs_running{%s} %d\n", labels(b), b.running)
+	}
+
+	fmt.Fprintln(&buf, "# HELP cbot_jobs_finished_total Number of finished jobs by status.")
+	fmt.Fprintln(&buf, "# TYPE cbot_jobs_finished_total counter")
+	for _, id := range ids {
+		b := e.bots[id]
+		for _, status := range []string{jobStatusString(JobStatusExit), jobStatusString(JobStatusError)} {
+			fmt.Fprintf(&buf, "cbot_jobs_finished_total{%s,status=\"%s\"} %d\n", labels(b), status, b.outcomes[status])
+		}
+	}
+
+	fmt.Fprintln(&buf, "# HELP cbot_job_elapsed_seconds Elapsed time of finished jobs reported by Cloud Bot.")
+	fmt.Fprintln(&buf, "# TYPE cbot_job_elapsed_seconds histogram")
+	for _, id := range ids {
+		b := e.bots[id]
+		for i, le := range elapsedTimeBuckets {
+			var n uint64
+			if b.elapsed.counts != nil {
+				n = b.elapsed.counts[i]
+			}
+			fmt.Fprintf(&buf, "cbot_job_elapsed_seconds_bucket{%s,le=\"%s\"} %d\n", labels(b), formatFloat(le), n)
+		}
+		fmt.Fprintf(&buf, "cbot_job_elapsed_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels(b), b.elapsed.count)
+		fmt.Fprintf(&buf, "cbot_job_elapsed_seconds_sum{%s} %s\n", labels(b), formatFloat(b.elapsed.sum))
+		fmt.Fprintf(&buf, "cbot_job_elapsed_seconds_count{%s} %d\n", labels(b), b.elapsed.count)
+	}
+
+	fmt.Fprintln(&buf, "# HELP cbot_last_success_timestamp_seconds Start time of the latest successful job.")
+	fmt.Fprintln(&buf, "# TYPE cbot_last_success_timestamp_seconds gauge")
+	for _, id := range ids {
+		b := e.bots[id]
+		if b.lastSuccess.IsZero() {
+			continue
+		}
+		fmt.Fprintf(&buf, "cbot_last_success_timestamp_seconds{%s} %d\n", labels(b), b.lastSuccess.Unix())
+	}
+
+	fmt.Fprintln(&buf, "# HELP cbot_scrape_errors_total Number of failed Cloud Bot API calls.")
+	fmt.Fprintln(&buf, "# TYPE cbot_scrape_errors_total counter")
+	keys := make([]string, 0, len(e.scrapeErrors))
+	for k := range e.scrapeErrors {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := strings.SplitN(k, "\x00", 2)
+		fmt.Fprintf(&buf, "cbot_scrape_errors_total{endpoint=\"%s\",reason=\"%s\"} %d\n", v[0], v[1], e.scrapeErrors[k])
+	}
+
+	success := 0
+	if e.lastSuccess {
+		success = 1
+	}
+	fmt.Fprintln(&buf, "# HELP cbot_scrape_success Whether the last polling of Cloud Bot succeeded.")
+	fmt.Fprintln(&buf, "# TYPE cbot_scrape_success gauge")
+	fmt.Fprintf(&buf, "cbot_scrape_success %d\n", success)
+
+	if !e.lastScrape.IsZero() {
+		fmt.Fprintln(&buf, "# HELP cbot_last_scrape_timestamp_seconds Time of the last polling of Cloud Bot.")
+		fmt.Fprintln(&buf, "# TYPE cbot_last_scrape_timestamp_seconds gauge")
+		fmt.Fprintf(&buf, "cbot_last_scrape_timestamp_seconds %d\n", e.lastScrape.Unix())
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	// set by batch runs, workflows and the scheduler
+	BulkOperation bool
+
+	guardMutex sync.Mutex
+	confirmed  = make(map[string]bool)
+)
+
+// protectionError is returned when the profile protection denies the
+// operation.
+type protectionError struct {
+	Reason string
+}
+
+func (e *protectionError) Error() string {
+	return fmt.Sprintf("denied by the protection of profile '%s'. %s", currentProfileName(), e.Reason)
+}
+
+// startBulkOperation marks the process as a bulk operation. It fails
+// before anything runs when the profile forbids bulk operations.
+func startBulkOperation() error {
+	BulkOperation = true
+	if UserConfig != nil && UserConfig.NoBulk {
+		return &protectionError{Reason: "bulk operations are forbidden."}
+	}
+	return nil
+}
+
+func (c *Config) isProtected() bool {
+	return c.ReadOnly || len(c.AllowedBots) > 0 || len(c.DeniedBots) > 0 || c.ConfirmMutations || c.NoBulk
+}
+
+// matchBotList reports whether the bot is in the list of ids, aliases
+// or names. An error is returned when a reference can not be resolved,
+// so that the callers never guess.
+func matchBotList(ctx context.Context, list []string, botId string) (bool, error) {
+	for _, ref := range list {
+		if ref == botId {
+			return true, nil
+		}
+	}
+	for _, ref := range list {
+		id, err := resolveBotId(ctx, ref)
+		if err != nil {
+			return false, fmt.Errorf("bot '%s' in the profile protection can not be resolved. %v", ref, err)
+		}
+		if id == botId {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// guardMutation enforces the protection of the current profile before
+// a job is run or aborted. botId may be empty for aborts, in which case
+// it is looked up from the job.
+func guardMutation(ctx context.Context, operation string, botId string, jobId string) error {
+	return guardConfirmedMutation(ctx, operation, botId, jobId, false)
+}
+
+// guardConfirmedMutation is guardMutation which does not ask the
+// confirmation when confirmed. The other protections are enforced.
+func guardConfirmedMutation(ctx context.Context, operation string, botId string, jobId string, confirmed bool) error {
+	c := UserConfig
+	if c == nil || !c.isProtected() {
+		return nil
+	}
+
+	botName := ""
+	if botId == "" && jobId != "" && !c.ReadOnly && !(c.NoBulk && BulkOperation) {
+		job, err := execShowJob(ctx, jobId)
+		if err != nil {
+			return &protectionError{Reason: fmt.Sprintf("the bot of job '%s' is unknown. %v", jobId, err)}
+		}
+		botId, botName = job.BotId, job.BotName
+	}
+
+	if err := checkProtection(ctx, c, botId); err != nil {
+		return err
+	}
+	if c.ConfirmMutations && !confirmed {
+		return confirmMutation(ctx, operation, botId, botName, jobId)
+	}
+	return nil
+}
+
+// guardProxyMutation is guardMutation for the proxy. Nobody can confirm
+// there, so the profile requiring confirmation denies every mutation.
+func guardProxyMutation(ctx context.Context, botId string) error {
+	c := UserConfig
+	if c == nil || !c.isProtected() {
+		return nil
+	}
+	if err := checkProtection(ctx, c, botId); err != nil {
+		return err
+	}
+	if c.ConfirmMutations {
+		return &protectionError{Reason: "confirmation is required, which the proxy can not ask."}
+	}
+	return nil
+}
+
+func checkProtection(ctx context.Context, c *Config, botId string) error {
+	if c.ReadOnly {
+		return &protectionError{Reason: "the profile is read-only."}
+	}
+	if c.NoBulk && BulkOperation {
+		return &protectionError{Reason: "bulk operations are forbidden."}
+	}
+	if len(c.AllowedBots) > 0 {
+		allowed, err := matchBotList(ctx, c.AllowedBots, botId)
+		if err != nil {
+			return &protectionError{Reason: err.Error()}
+		}
+		if !allowed {
+			return &protectionError{Reason: fmt.Sprintf("bot id '%s' is not allowed.", botId)}
+		}
+	}
+	denied, err := matchBotList(ctx, c.DeniedBots, botId)
+	if err != nil {
+		return &protectionError{Reason: err.Error()}
+	}
+	if denied {
+		return &protectionError{Reason: fmt.Sprintf("bot id '%s' is denied.", botId)}
+	}
+	return nil
+}
+
+// confirmMutation asks the user to type the bot name. Each bot is
+// confirmed once per operation so that retries do not ask again.
+func confirmMutation(ctx context.Context, operation string, botId string, botName string, jobId string) error {
+	guardMutex.Lock()
+	defer guardMutex.Unlock()
+
+	key := operation + "\x00" + botId
+	if confirmed[key] {
+		return nil
+	}
+	if !isInteractive() {
+		return &protectionError{Reason: "confirmation is required. Run on a terminal."}
+	}
+
+	if botName == "" {
+		if bot, _, err := cachedFetchBot(ctx, botId); err == nil {
+			botName = bot.Name
+		}
+	}
+	expected := botName
+	if expected == "" {
+		expected = botId
+	}
+
+	target := fmt.Sprintf("bot '%s' (%s)", expected, botId)
+	if jobId != "" {
+		target = fmt.Sprintf("job '%s' of %s", jobId, target)
+	}
+	fmt.Fprintf(os.Stderr, "profile '%s' is protected. You are going to %s %s.\n", currentProfileName(), operation, target)
+	v, err := readPromptLine(fmt.Sprintf("Type the bot name '%s' to continue: ", expected))
+	if err != nil || strings.TrimSpace(v) != expected {
+		return &protectionError{Reason: "the confirmation did not match."}
+	}
+	confirmed[key] = true
+	return nil
+}
@@ -0,0 +1,535 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	HistoryFileName = "history.jsonl"
+
+	HistoryStatusFailed = "failed"
+
+	MaskedValue = "****"
+)
+
+var (
+	HistoryNotFoundError = errors.New("Specified history is not found")
+
+	// input keys containing these words are masked in the history
+	secretInputKeywords = []string{"password", "passwd", "secret", "token", "credential"}
+	// input keys having these words are masked. They are matched as
+	// whole words so that monkey or author are not masked.
+	secretInputWords = map[string]bool{
+		"key": true, "apikey": true, "accesskey": true, "privatekey": true,
+		"auth": true, "authorization": true,
+	}
+
+	historyMutex sync.Mutex
+	// jobs recorded by this process, which are updated without reading
+	// the history file
+	recordedJobIds = make(map[string]bool)
+)
+
+type historyRecord struct {
+	Id               string            `json:"id"`
+	Time             time.Time         `json:"time"`
+	Updated          time.Time         `json:"updated"`
+	Profile          string            `json:"profile"`
+	BotId            string            `json:"bot_id"`
+	BotName          string            `json:"bot_name,omitempty"`
+	Input            map[string]string `json:"input"`
+	TimeoutTime      int               `json:"timeout_time"`
+	CallbackEndpoint string            `json:"callback_endpoint,omitempty"`
+	CallbackTries    int               `json:"callback_tries"`
+	JobId            string            `json:"job_id,omitempty"`
+	Status           string            `json:"status"`
+	ElapsedTime      int               `json:"elapsed_time,omitempty"`
+	Error            string            `json:"error,omitempty"`
+}
+
+type historyFilter struct {
+	BotId   string
+	Status  string
+	Profile string
+	Since   time.Time
+	Until   time.Time
+	Text    string
+	Limit   int
+}
+
+func getHistoryPath() string {
+	return filepath.Join(getConfigDir(), HistoryFileName)
+}
+
+// splitInputKeyWords splits the input key into lower case words at
+// the non alphanumerics and the camel case boundaries.
+// (ex: api_key, secretKey -> [api key], [secret key])
+func splitInputKeyWords(key string) []string {
+	var words []string
+	var word []rune
+	prevLower := false
+	for _, c := range key {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+			if len(word) > 0 {
+				words = append(words, string(word))
+			}
+			word, prevLower = nil, false
+			continue
+		}
+		if unicode.IsUpper(c) && prevLower {
+			words = append(words, string(word))
+			word = nil
+		}
+		word = append(word, unicode.ToLower(c))
+		prevLower = unicode.IsLower(c) || unicode.IsDigit(c)
+	}
+	if len(word) > 0 {
+		words = append(words, string(word))
+	}
+	return words
+}
+
+func isSecretInputKey(key string) bool {
+	k := strings.ToLower(key)
+	for _, w := range secretInputKeywords {
+		if strings.Contains(k, w) {
+			return true
+		}
+	}
+	for _, w := range splitInputKeyWords(key) {
+		if secretInputWords[w] {
+			return true
+		}
+	}
+	return false
+}
+
+func maskInput(input map[string]string) map[string]string {
+	masked := make(map[string]string)
+	for k, v := range input {
+		if isSecretInputKey(k) {
+			masked[k] = MaskedValue
+		} else {
+			masked[k] = v
+		}
+	}
+	return masked
+}
+
+func newLocalHistoryId() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return "local-" + hex.EncodeToString(b)
+}
+
+func appendHistory(rec historyRecord) error {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(getHistoryPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(b, '\n'))
+	return err
+}
+
+// recordRun records a bot execution requested from this CLI.
+// Failures of recording are reported but never stop the execution.
+func recordRun(botId string, param execParameter, ret *execBotResponse, runErr error) {
+	now := time.Now()
+	rec := historyRecord{
+		Time:             now,
+		Updated:          now,
+		Profile:          currentProfileName(),
+		BotId:            botId,
+		Input:            maskInput(param.Input),
+		TimeoutTime:      param.TimeoutTime,
+		CallbackEndpoint: param.CallbackEndpoint,
+		CallbackTries:    param.CallbackTries,
+	}
+	if runErr != nil {
+		rec.Id = newLocalHistoryId()
+		rec.Status = HistoryStatusFailed
+		rec.Error = runErr.Error()
+	} else {
+		rec.Id = ret.JobId
+		rec.JobId = ret.JobId
+		rec.BotName = ret.BotName
+		rec.Status = jobStatusString(ret.Status)
+	}
+
+	if err := appendHistory(rec); err != nil {
+		fmt.Fprintf(os.Stderr, "history record failed. %v\n", err)
+		return
+	}
+	if rec.JobId != "" {
+		historyMutex.Lock()
+		recordedJobIds[rec.JobId] = true
+		historyMutex.Unlock()
+	}
+}
+
+// recordJobStatus records the final status of the job once it is known.
+// Only the jobs run from this CLI are updated, so that watching other
+// jobs does not grow the history.
+func recordJobStatus(job *showJobResponse) {
+	if ok, err := hasHistoryRecord(job.JobId); err != nil || !ok {
+		return
+	}
+
+	rec := historyRecord{
+		Id:          job.JobId,
+		Updated:     time.Now(),
+		JobId:       job.JobId,
+		BotId:       job.BotId,
+		BotName:     job.BotName,
+		Status:      job.StatusString(),
+		ElapsedTime: job.ElapsedTime,
+		Error:       job.Message,
+	}
+	if err := appendHistory(rec); err != nil {
+		fmt.Fprintf(os.Stderr, "history record failed. %v\n", err)
+	}
+}
+
+// hasHistoryRecord reports whether the run of the id is recorded. The
+// file is read only for the jobs run by other processes.
+func hasHistoryRecord(id string) (bool, error) {
+	historyMutex.Lock()
+	recorded := recordedJobIds[id]
+	historyMutex.Unlock()
+	if recorded {
+		return true, nil
+	}
+
+	records, err := loadHistory()
+	if err != nil {
+		return false, err
+	}
+	for _, r := range records {
+		if r.Id == id {
+			historyMutex.Lock()
+			recordedJobIds[id] = true
+			historyMutex.Unlock()
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// loadHistory reads the history file and merges the status updates
+// into their records. Records are ordered from the newest.
+func loadHistory() ([]*historyRecord, error) {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+
+	path := getHistoryPath()
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	records := make(map[string]*historyRecord)
+	var order []*historyRecord
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var rec historyRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("history load failed. %v: %v", path, err)
+		}
+
+		base, ok := records[rec.Id]
+		if !ok {
+			if rec.Time.IsZero() {
+				// status update of a run not recorded
+				continue
+			}
+			r := rec
+			records[rec.Id] = &r
+			order = append(order, &r)
+			continue
+		}
+		base.Updated = rec.Updated
+		base.Status = rec.Status
+		base.ElapsedTime = rec.ElapsedTime
+		base.Error = rec.Error
+		if rec.BotName != "" {
+			base.BotName = rec.BotName
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return order[i].Time.After(order[j].Time)
+	})
+	return order, nil
+}
+
+func (r *historyRecord) inputString() string {
+	keys := make([]string, 0, len(r.Input))
+	for k := range r.Input {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+":"+r.Input[k])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (r *historyRecord) contains(text string) bool {
+	text = strings.ToLower(text)
+	for _, s := range []string{r.Id, r.BotId, r.BotName, r.JobId, r.Status, r.Error, r.CallbackEndpoint, r.inputString()} {
+		if strings.Contains(strings.ToLower(s), text) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *historyFilter) match(r *historyRecord) bool {
+	if f.BotId != "" && r.BotId != f.BotId {
+		return false
+	}
+	if f.Status != "" && r.Status != f.Status {
+		return false
+	}
+	if f.Profile != "" && r.Profile != f.Profile {
+		return false
+	}
+	if !f.Since.IsZero() && r.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !r.Time.Before(f.Until) {
+		return false
+	}
+	if f.Text != "" && !r.contains(f.Text) {
+		return false
+	}
+	return true
+}
+
+func filterHistory(records []*historyRecord, f historyFilter) []*historyRecord {
+	var ret []*historyRecord
+	for _, r := range records {
+		if !f.match(r) {
+			continue
+		}
+		ret = append(ret, r)
+		if f.Limit > 0 && len(ret) >= f.Limit {
+			break
+		}
+	}
+	return ret
+}
+
+// parseTimeArg accepts a date (2006-01-02), a date time
+// (2006-01-02 15:04) or a duration back from now (24h, 7d).
+func parseTimeArg(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if strings.HasSuffix(s, "d") {
+		if n, err := strconv.Atoi(strings.TrimSuffix(s, "d")); err == nil {
+			return time.Now().AddDate(0, 0, -n), nil
+		}
+	}
+	for _, layout := range []string{"2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalidate time '%s'. Ex: 2006-01-02, '2006-01-02 15:04', 24h or 7d", s)
+}
+
+func historyCommand(args []string) {
+	var formatType string
+	var botId string
+	var status string
+	var profile string
+	var since string
+	var until string
+	var limit int
+
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	fs.StringVar(&formatType, "f", "text", "output format type")
+	fs.StringVar(&botId, "b", "", "filter by bot id")
+	fs.StringVar(&status, "status", "", "filter by status")
+	fs.StringVar(&profile, "profile", "", "filter by profile")
+	fs.StringVar(&since, "since", "", "filter runs since the time")
+	fs.StringVar(&until, "until", "", "filter runs until the time")
+	fs.IntVar(&limit, "n", 20, "maximum number of runs")
+
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, `Usage: cbot-cli history [OPTION]... list
+       cbot-cli history [OPTION]... search TEXT
+       cbot-cli history [OPTION]... show ID
+  Commands:
+    list           : listing runs requested from this CLI from the newest.
+    search TEXT    : listing runs containing TEXT in the bot, job, status or inputs.
+    show ID        : show the run of the history id or job id.
+  Options:
+    -b BOT         : filter by bot.
+    -status STATUS : filter by status.(running, exit, error, failed)
+    -profile NAME  : filter by profile.
+    -since TIME    : filter runs since TIME.(ex: 2006-01-02, '2006-01-02 15:04', 24h, 7d)
+    -until TIME    : filter runs before TIME.
+    -n NUMBER      : maximum number of runs. 0 is unlimited.[default 20]
+    -f json | text : output format type.[default 'text']
+`)
+	}
+
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	if botId != "" {
+		// the history is local. references which can not be resolved
+		// are matched as they are.
+		if id, err := resolveBotId(commandContext(), botId); err == nil {
+			botId = id
+		}
+	}
+
+	filter := historyFilter{BotId: botId, Status: status, Profile: profile, Limit: limit}
+	var err error
+	if filter.Since, err = parseTimeArg(since); err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		os.Exit(1)
+	}
+	if filter.Until, err = parseTimeArg(until); err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		os.Exit(1)
+	}
+
+	switch fs.Arg(0) {
+	case "list":
+		err = execListingHistory(filter, formatType)
+	case "search":
+		if fs.NArg() < 2 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		filter.Text = fs.Arg(1)
+		err = execListingHistory(filter, formatType)
+	case "show":
+		if fs.NArg() < 2 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		err = execShowHistory(fs.Arg(1), formatType)
+		if err == HistoryNotFoundError {
+			fmt.Fprintf(os.Stderr, "history '%s' is not found.", fs.Arg(1))
+			os.Exit(1)
+		}
+	default:
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		os.Exit(1)
+	}
+}
+
+func execListingHistory(filter historyFilter, format string) error {
+	records, err := loadHistory()
+	if err != nil {
+		return err
+	}
+	records = filterHistory(records, filter)
+
+	if format != "text" {
+		if records == nil {
+			records = []*historyRecord{}
+		}
+		b, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+
+	fmt.Println("id\ttime\tprofile\tbot_id\tjob_id\tstatus\tinput")
+	for _, r := range records {
+		fmt.Printf("%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.Id, r.Time.Local().Format("2006-01-02 15:04:05"), r.Profile, r.BotId, r.JobId, r.Status, r.inputString())
+	}
+	return nil
+}
+
+func execShowHistory(id string, format string) error {
+	records, err := loadHistory()
+	if err != nil {
+		return err
+	}
+
+	for _, r := range records {
+		if r.Id != id && r.JobId != id {
+			continue
+		}
+
+		if format != "text" {
+			b, err := json.MarshalIndent(r, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(b))
+			return nil
+		}
+
+		fmt.Printf("Id                : %s\n", r.Id)
+		fmt.Printf("Time              : %s\n", r.Time.Local().Format("2006-01-02 15:04:05"))
+		fmt.Printf("Updated           : %s\n", r.Updated.Local().Format("2006-01-02 15:04:05"))
+		fmt.Printf("Profile           : %s\n", r.Profile)
+		fmt.Printf("Bot Id            : %s\n", r.BotId)
+		fmt.Printf("Bot Name          : %s\n", r.BotName)
+		fmt.Printf("Job Id            : %s\n", r.JobId)
+		fmt.Printf("Status            : %s\n", r.Status)
+		fmt.Printf("Elapsed Time      : %d\n", r.ElapsedTime)
+		fmt.Printf("Input             : %s\n", r.inputString())
+		fmt.Printf("Timeout Time      : %d\n", r.TimeoutTime)
+		fmt.Printf("Callback Endpoint : %s\n", r.CallbackEndpoint)
+		fmt.Printf("Callback Tries    : %d\n", r.CallbackTries)
+		fmt.Printf("Error             : %s\n", r.Error)
+		return nil
+	}
+	return HistoryNotFoundError
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	InterruptExitCode = 130
+)
+
+var (
+	// set by the global --abort-on-interrupt option
+	AbortOnInterrupt bool
+
+	InterruptedError = errors.New("interrupted")
+)
+
+// jobFollower handles Ctrl-C while jobs are followed. The polling is
+// cancelled through the context, and the followed jobs are aborted
+// with --abort-on-interrupt or when the user agrees on the prompt.
+type jobFollower struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	autoAbort bool
+	sig       chan os.Signal
+	done      chan struct{}
+	// closed when the interruption is handled
+	handled chan struct{}
+
+	mu          sync.Mutex
+	jobs        map[string]bool
+	interrupted bool
+	// the jobs asked to abort on the interruption
+	asked map[string]bool
+}
+
+// newJobFollower follows jobs until ctx is done or Ctrl-C is pressed.
+func newJobFollower(parent context.Context) *jobFollower {
+	ctx, cancel := context.WithCancel(parent)
+	f := &jobFollower{
+		ctx:       ctx,
+		cancel:    cancel,
+		autoAbort: AbortOnInterrupt,
+		sig:       make(chan os.Signal, 1),
+		done:      make(chan struct{}),
+		handled:   make(chan struct{}),
+		jobs:      make(map[string]bool),
+	}
+	signal.Notify(f.sig, os.Interrupt, syscall.SIGTERM)
+	go f.handle()
+	return f
+}
+
+// Stop restores the default signal handling.
+func (f *jobFollower) Stop() {
+	signal.Stop(f.sig)
+	close(f.done)
+}
+
+func (f *jobFollower) add(jobId string) {
+	f.mu.Lock()
+	f.jobs[jobId] = true
+	f.mu.Unlock()
+}
+
+func (f *jobFollower) remove(jobId string) {
+	f.mu.Lock()
+	delete(f.jobs, jobId)
+	f.mu.Unlock()
+}
+
+func (f *jobFollower) isInterrupted() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.interrupted
+}
+
+// interruptedError waits for the interruption to be handled and returns
+// InterruptedError. The callers exit with InterruptExitCode.
+func (f *jobFollower) interruptedError() error {
+	<-f.handled
+	return InterruptedError
+}
+
+// waitJob is waitJob of the followed job. When interrupted, it returns
+// InterruptedError after the job is aborted or left running.
+func (f *jobFollower) waitJob(jobId string, interval time.Duration) (*showJobResponse, error) {
+	f.add(jobId)
+	defer f.remove(jobId)
+
+	job, err := waitJob(f.ctx, jobId, interval)
+	if err != nil && f.isInterrupted() {
+		err = f.interruptedError()
+		f.mu.Lock()
+		asked := f.asked[jobId]
+		f.mu.Unlock()
+		if !asked {
+			// followed after the interruption was handled
+			fmt.Fprintf(os.Stderr, "job '%s' keeps running. Follow it with: cbot-cli watch %s\n", jobId, jobId)
+		}
+		return nil, err
+	}
+	if err == context.DeadlineExceeded {
+		return nil, fmt.Errorf("timed out. job '%s' keeps running. Follow it with: cbot-cli watch %s", jobId, jobId)
+	}
+	return job, err
+}
+
+func (f *jobFollower) handle() {
+	select {
+	case <-f.sig:
+	case <-f.done:
+		return
+	}
+	// a second Ctrl-C terminates immediately
+	signal.Stop(f.sig)
+
+	f.mu.Lock()
+	f.interrupted = true
+	var jobs []string
+	f.asked = make(map[string]bool)
+	for id := range f.jobs {
+		jobs = append(jobs, id)
+		f.asked[id] = true
+	}
+	f.mu.Unlock()
+	sort.Strings(jobs)
+
+	f.cancel()
+	releaseLocks()
+	fmt.Fprintln(os.Stderr)
+
+	if len(jobs) > 0 {
+		abort := f.autoAbort
+		if !abort && isInteractive() {
+			abort = askYesNo(fmt.Sprintf("interrupted. Abort job %s on Cloud Bot?", strings.Join(jobs, ", ")), false)
+		}
+		// the context of the command is cancelled already
+		ctx := context.Background()
+		for _, id := range jobs {
+			if !abort {
+				fmt.Fprintf(os.Stderr, "job '%s' keeps running. Follow it with: cbot-cli watch %s\n", id, id)
+				continue
+			}
+			// the user agreed already. the protection does not ask again.
+			if err := execAbortJob(ctx, id, true); err != nil && err != JobAlreadyDoneError {
+				fmt.Fprintf(os.Stderr, "abort job '%s' failed. %v\n", id, err)
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "job '%s' is aborted.\n", id)
+		}
+	}
+	close(f.handled)
+}
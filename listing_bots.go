@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -25,7 +26,7 @@ type listingBotsResponseBot struct {
 }
 
 func listingBotsPortal(format string) {
-	err := execListingBots(format)
+	err := execListingBots(commandContext(), format)
 	if err == UnauthorizedError {
 		fmt.Fprintf(os.Stderr, "unauthorized error returned. Check your access token and key.")
 		os.Exit(1)
@@ -53,13 +54,13 @@ func buildListingBotsURL() (string, error) {
 	return u.String(), nil
 }
 
-func buildListingBotsRequest() (*http.Request, error) {
+func buildListingBotsRequest(ctx context.Context) (*http.Request, error) {
 	url, err := buildListingBotsURL()
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -72,16 +73,16 @@ func buildListingBotsRequest() (*http.Request, error) {
 	return req, nil
 }
 
-func processListingBotsResponse(resp *http.Response, format string) error {
+func processListingBotsResponse(resp *http.Response) (*listingBotsResponse, []byte, error) {
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	var ret listingBotsResponse
 	err = json.Unmarshal(body, &ret)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	switch ret.Code {
@@ -89,45 +90,51 @@ func processListingBotsResponse(resp *http.Response, format string) error {
 		// nothing todo
 	case 401:
 		// unauthorized
-		return UnauthorizedError
+		return nil, nil, UnauthorizedError
 	case 403:
 		// forbidden
-		return ForbiddenError
+		return nil, nil, ForbiddenError
 	default:
 		// error
-		return fmt.Errorf("response code '%d' returned.", ret.Code)
-	}
-
-	if format != "text" {
-		fmt.Println(string(body))
-		return nil
+		return nil, nil, &responseCodeError{Code: ret.Code}
 	}
 
-	fmt.Println("id\tname\tdescription\tcreated\tlast_modified\tcreator")
-	for _, r := range ret.Bots {
-		fmt.Printf("%s\t%s\t%s\t%s\t%s\t%s\n", r.Id, r.Name, r.Description, r.Created, r.LastModified, r.Creator)
-	}
-
-	return nil
+	return &ret, body, nil
 }
 
-func execListingBots(format string) error {
+// fetchBots returns the bots together with the raw response body.
+func fetchBots(ctx context.Context) (*listingBotsResponse, []byte, error) {
 	client := http.DefaultClient
 
-	req, err := buildListingBotsRequest()
+	req, err := buildListingBotsRequest(ctx)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
 
-	if err := processListingBotsResponse(resp, format); err != nil {
+	return processListingBotsResponse(resp)
+}
+
+func execListingBots(ctx context.Context, format string) error {
+	ret, body, err := cachedFetchBots(ctx)
+	if err != nil {
 		return err
 	}
 
+	if format != "text" {
+		fmt.Println(string(body))
+		return nil
+	}
+
+	fmt.Println("id\tname\tdescription\tcreated\tlast_modified\tcreator")
+	for _, r := range ret.Bots {
+		fmt.Printf("%s\t%s\t%s\t%s\t%s\t%s\n", r.Id, r.Name, r.Description, r.Created, r.LastModified, r.Creator)
+	}
+
 	return nil
 }
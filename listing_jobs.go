@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -33,7 +34,11 @@ type listingJobsResponseJob struct {
 }
 
 func (rj *listingJobsResponseJob) StatusString() string {
-	switch rj.Status {
+	return jobStatusString(rj.Status)
+}
+
+func jobStatusString(status int) string {
+	switch status {
 	case JobStatusExit:
 		return "exit"
 	case JobStatusError:
@@ -46,7 +51,7 @@ func (rj *listingJobsResponseJob) StatusString() string {
 }
 
 func listingJobsPortal(botId string, format string) {
-	err := execListingJobs(botId, format)
+	err := execListingJobs(commandContext(), botId, format)
 	if err == UnauthorizedError {
 		fmt.Fprintf(os.Stderr, "unauthorized error returned. Check your access token and key.")
 		os.Exit(1)
@@ -74,13 +79,13 @@ func buildListingJobsURL(botId string) (string, error) {
 	return u.String(), nil
 }
 
-func buildListingJobsRequest(botId string) (*http.Request, error) {
+func buildListingJobsRequest(ctx context.Context, botId string) (*http.Request, error) {
 	url, err := buildListingJobsURL(botId)
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -93,16 +98,16 @@ func buildListingJobsRequest(botId string) (*http.Request, error) {
 	return req, nil
 }
 
-func processListingJobsResponse(resp *http.Response, format string) error {
+func processListingJobsResponse(resp *http.Response) (*listingJobsResponse, []byte, error) {
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	var ret listingJobsResponse
 	err = json.Unmarshal(body, &ret)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	switch ret.Code {
@@ -110,45 +115,51 @@ func processListingJobsResponse(resp *http.Response, format string) error {
 		// nothing todo
 	case 401:
 		// unauthorized
-		return UnauthorizedError
+		return nil, nil, UnauthorizedError
 	case 403:
 		// forbidden
-		return ForbiddenError
+		return nil, nil, ForbiddenError
 	default:
 		// error
-		return fmt.Errorf("response code '%d' returned.", ret.Code)
+		return nil, nil, &responseCodeError{Code: ret.Code}
 	}
 
-	if format != "text" {
-		fmt.Println(string(body))
-		return nil
-	}
-
-	fmt.Println("job_id\tbot_id\tbot_name\tstatus\tstart_time\telapsed_time")
-	for _, r := range ret.Jobs {
-		fmt.Printf("%s\t%s\t%s\t%s\t%s\t%d\n", r.JobId, r.BotId, r.BotName, r.StatusString(), r.StartTime, r.ElapsedTime)
-	}
-
-	return nil
+	return &ret, body, nil
 }
 
-func execListingJobs(botId string, format string) error {
+// fetchJobs returns the jobs of the bot together with the raw response body.
+func fetchJobs(ctx context.Context, botId string) (*listingJobsResponse, []byte, error) {
 	client := http.DefaultClient
 
-	req, err := buildListingJobsRequest(botId)
+	req, err := buildListingJobsRequest(ctx, botId)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
 
-	if err := processListingJobsResponse(resp, format); err != nil {
+	return processListingJobsResponse(resp)
+}
+
+func execListingJobs(ctx context.Context, botId string, format string) error {
+	ret, body, err := fetchJobs(ctx, botId)
+	if err != nil {
 		return err
 	}
 
+	if format != "text" {
+		fmt.Println(string(body))
+		return nil
+	}
+
+	fmt.Println("job_id\tbot_id\tbot_name\tstatus\tstart_time\telapsed_time")
+	for _, r := range ret.Jobs {
+		fmt.Printf("%s\t%s\t%s\t%s\t%s\t%d\n", r.JobId, r.BotId, r.BotName, r.StatusString(), r.StartTime, r.ElapsedTime)
+	}
+
 	return nil
 }
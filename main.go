@@ -5,6 +5,9 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var (
@@ -18,13 +21,27 @@ var (
 	BotAlreadyRunningError     = errors.New("Specified bot is already running")
 	TooManyExecuteRequestError = errors.New("Too many requests error returned from server")
 	BotExecutionIsAbortedError = errors.New("Specified bot execution is aborted")
+	JobFailedError             = errors.New("Specified job finished with error")
 )
 
+// responseCodeError is returned when the server answers with a response
+// code which has no error of its own.
+type responseCodeError struct {
+	Code int
+}
+
+func (e *responseCodeError) Error() string {
+	return fmt.Sprintf("response code '%d' returned.", e.Code)
+}
+
 func setup() {
 	var err error
 
 	UserConfig, err = getConfig()
-	if err == ConfigFileNotFoundError {
+	if err == ProfileNotFoundError {
+		fmt.Fprintf(os.Stderr, "profile '%s' is not found. Setup the profile.\n", currentProfileName())
+	}
+	if err == ConfigFileNotFoundError || err == ProfileNotFoundError {
 		UserConfig, err = createConfigFile()
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "config file create failed.\n%v", err)
@@ -36,9 +53,156 @@ func setup() {
 	}
 }
 
+func setGlobalOption(name string, value string) {
+	switch strings.TrimLeft(name, "-") {
+	case "config":
+		ConfigPathOverride = value
+	case "profile":
+		ProfileNameOverride = value
+	case "timeout":
+		d, err := time.ParseDuration(value)
+		if n, nerr := strconv.Atoi(value); nerr == nil {
+			d, err = time.Duration(n)*time.Second, nil
+		}
+		if err != nil || d <= 0 {
+			fmt.Fprintf(os.Stderr, "invalidate timeout '%s'. Ex: 30s, 5m or 90", value)
+			os.Exit(1)
+		}
+		CommandTimeout = d
+	}
+}
+
+// topLevelValueOptions are the options of the top level taking a value,
+// which is not the command name.
+var topLevelValueOptions = map[string]bool{
+	"s": true, "j": true, "a": true, "f": true, "t": true, "u": true, "T": true, "i": true,
+}
+
+// parseGlobalOptions applies the options usable with every command
+// and returns the rest of args. The options are parsed until the
+// command name or "--", so that the args of the commands and the
+// plugins are passed as they are. --profile needs two dashes since
+// -profile is a filter of history.
+func parseGlobalOptions(args []string) []string {
+	if v := os.Getenv(OfflineModeEnvName); v != "" && v != "0" && v != "false" {
+		CatalogOffline = true
+	}
+
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		switch {
+		case a == "--":
+			return append(rest, args[i+1:]...)
+		case !strings.HasPrefix(a, "-") || a == "-":
+			return append(rest, args[i:]...)
+		case topLevelValueOptions[strings.TrimLeft(a, "-")]:
+			rest = append(rest, a)
+			if i+1 < len(args) {
+				i++
+				rest = append(rest, args[i])
+			}
+		case a == "-refresh" || a == "--refresh":
+			CatalogRefresh = true
+		case a == "-offline" || a == "--offline":
+			CatalogOffline = true
+		case a == "-abort-on-interrupt" || a == "--abort-on-interrupt":
+			AbortOnInterrupt = true
+		case a == "-config" || a == "--config" || a == "--profile" ||
+			a == "-timeout" || a == "--timeout":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "option %s needs a value.", a)
+				os.Exit(1)
+			}
+			i++
+			setGlobalOption(a, args[i])
+		case strings.HasPrefix(a, "-config=") || strings.HasPrefix(a, "--config=") ||
+			strings.HasPrefix(a, "--profile=") ||
+			strings.HasPrefix(a, "-timeout=") || strings.HasPrefix(a, "--timeout="):
+			n := strings.Index(a, "=")
+			setGlobalOption(a[:n], a[n+1:])
+		default:
+			rest = append(rest, a)
+		}
+	}
+	return rest
+}
+
 func main() {
+	os.Args = append(os.Args[:1], parseGlobalOptions(os.Args[1:])...)
+	if err := validateProfileName(currentProfileName()); err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		os.Exit(1)
+	}
+	migrateLegacyConfig()
+	startCommandContext()
+
+	// these commands do not need the configuration setup
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "doctor":
+			doctorCommand(os.Args[2:])
+			os.Exit(0)
+		case "config":
+			configCommand(os.Args[2:])
+			os.Exit(0)
+		case "completion":
+			completionCommand(os.Args[2:])
+			os.Exit(0)
+		case "__complete":
+			hiddenCompleteCommand(os.Args[2:])
+			os.Exit(0)
+		}
+	}
+
 	setup()
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "run":
+			runCommand(os.Args[2:])
+			os.Exit(0)
+		case "scheduler":
+			schedulerCommand(os.Args[2:])
+			os.Exit(0)
+		case "workflow":
+			workflowCommand(os.Args[2:])
+			os.Exit(0)
+		case "history":
+			historyCommand(os.Args[2:])
+			os.Exit(0)
+		case "stats":
+			statsCommand(os.Args[2:])
+			os.Exit(0)
+		case "exporter":
+			exporterCommand(os.Args[2:])
+			os.Exit(0)
+		case "watch":
+			watchCommand(os.Args[2:])
+			os.Exit(0)
+		case "notify":
+			notifyCommand(os.Args[2:])
+			os.Exit(0)
+		case "alias":
+			aliasCommand(os.Args[2:])
+			os.Exit(0)
+		case "audit":
+			auditCommand(os.Args[2:])
+			os.Exit(0)
+		case "proxy":
+			proxyCommand(os.Args[2:])
+			os.Exit(0)
+		case "bots":
+			botsCommand(os.Args[2:])
+			os.Exit(0)
+		}
+
+		// cbot-cli NAME runs cbot-cli-NAME on PATH
+		if path, ok := findPlugin(os.Args[1]); ok {
+			runPlugin(path, os.Args[2:])
+		}
+	}
+
 	var doDisplayProfile bool
 	var doReconfigProfile bool
 	var doListingBots bool
@@ -64,7 +228,23 @@ func main() {
 	flag.StringVar(&execInputParam, "i", "", "input parameters for execute bot")
 
 	flag.Usage = func() {
-		fmt.Fprint(os.Stderr, `Usage: cbot-cli [OPTION]... [EXECUTE BOT_ID]
+		fmt.Fprint(os.Stderr, `Usage: cbot-cli [OPTION]... [EXECUTE BOT]
+       cbot-cli run [OPTION]... [BOT]
+       cbot-cli scheduler [OPTION]... [run | list | next]
+       cbot-cli workflow [OPTION]... FILE
+       cbot-cli history [OPTION]... [list | search TEXT | show ID]
+       cbot-cli stats [OPTION]...
+       cbot-cli exporter [OPTION]...
+       cbot-cli watch [OPTION]... [JOB_ID]
+       cbot-cli notify [OPTION]... test [NAME]
+       cbot-cli completion bash | zsh | fish
+       cbot-cli doctor [OPTION]...
+       cbot-cli proxy [OPTION]... | proxy token
+       cbot-cli audit [OPTION]... list
+       cbot-cli config [list | get KEY | set KEY VALUE | unset KEY | profiles | edit | restore]
+       cbot-cli alias [list | set NAME [BOT] | unset NAME]
+       cbot-cli bots diff [OPTION]... | bots export DIR
+       cbot-cli PLUGIN [ARG]...
   Options:
     -i             : input parameters for execute bot.(ex: key:value,key2:value2...)[default '']
     -t             : timeout time at bot execution.(0-25000)[default 0]
@@ -74,11 +254,43 @@ func main() {
     -r             : reconfiguration profile.
     -h             : display this help.
     -l             : listing your bots.
-    -s BOT_ID      : show specify bot detail.
-    -j BOT_ID      : listing specify bot jobs.
+    -s BOT         : show specify bot detail.
+    -j BOT         : listing specify bot jobs.
     -a JOB_ID      : abort specify bot job.
     -f json | text : output format type.[default 'json'] (support listing options only)
+  Global options:(before the command)
+    --refresh      : refresh the cached bot catalog.
+    --offline      : use the cached bot catalog only.(or CBOT_OFFLINE=1)
+    --config FILE  : config file.(or CBOT_CONFIG=FILE)[default ~/.config/cbot/settings.json]
+    --profile NAME : config profile.(or CBOT_PROFILE=NAME)[default 'default']
+    --abort-on-interrupt
+                   : abort the followed jobs on Ctrl-C without asking.
+    --timeout DURATION
+                   : give up the whole command after DURATION.(ex: 30s, 5m)
+                     Each run of scheduler and each request of exporter and proxy
+                     is bounded instead.
+  Commands:
+    run            : execute bot. Supports waiting and batch runs. (see 'cbot-cli run -h')
+    scheduler      : trigger bot runs by cron schedules. (see 'cbot-cli scheduler -h')
+    workflow       : run bots in order defined by a workflow file. (see 'cbot-cli workflow -h')
+    history        : listing runs requested from this CLI. (see 'cbot-cli history -h')
+    stats          : job statistics per bot. (see 'cbot-cli stats -h')
+    exporter       : serve Prometheus metrics of bots and jobs. (see 'cbot-cli exporter -h')
+    watch          : wait for the job to finish. (see 'cbot-cli watch -h')
+    notify         : test the job completion notifications. (see 'cbot-cli notify -h')
+    completion     : print the shell completion script. (see 'cbot-cli completion')
+    alias          : manage bot aliases. (see 'cbot-cli alias')
+    bots           : compare or export the bot definitions. (see 'cbot-cli bots')
+    config         : manage the config without prompts. (see 'cbot-cli config')
+    audit          : listing runs and aborts requested from this CLI. (see 'cbot-cli audit -h')
+    proxy          : serve the API to the team with per-user tokens. (see 'cbot-cli proxy -h')
+    doctor         : check the configuration and the connectivity. (see 'cbot-cli doctor -h')
+  BOT is a bot id, an alias or a bot name(exact or unique prefix).
+  EXECUTE BOT is a bot id, an alias or an exact bot name.
+  On a terminal, omitted BOT and JOB_ID of commands are picked from a list.
+  PLUGIN runs cbot-cli-PLUGIN on PATH with the profile in CBOT_* variables.
 `)
+		fmt.Fprint(os.Stderr, pluginUsage())
 	}
 
 	flag.Parse()
@@ -90,7 +302,10 @@ func main() {
 	}
 
 	if doReconfigProfile == true {
-		updateConfigFile()
+		if _, err := updateConfigFile(); err != nil {
+			fmt.Fprintf(os.Stderr, "config file update failed.\n%v", err)
+			os.Exit(1)
+		}
 		os.Exit(0)
 	}
 
@@ -100,12 +315,12 @@ func main() {
 	}
 
 	if showBotId != "" {
-		showBotPortal(showBotId)
+		showBotPortal(resolveBotIdPortal(showBotId))
 		os.Exit(0)
 	}
 
 	if listingJobsBotId != "" {
-		listingJobsPortal(listingJobsBotId, formatType)
+		listingJobsPortal(resolveBotIdPortal(listingJobsBotId), formatType)
 		os.Exit(0)
 	}
 
@@ -126,7 +341,18 @@ func main() {
 		execInputParam:   execInputParam,
 	}
 
-	execBotPortal(args[0], p)
+	botId, ok, err := resolveExactBotId(commandContext(), args[0])
+	if err == UnauthorizedError {
+		fmt.Fprintf(os.Stderr, "unauthorized error returned. Check your access token and key.")
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		os.Exit(1)
+	} else if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command '%s'. Use 'cbot-cli run BOT' to run a bot by a name prefix or a hidden id.", args[0])
+		os.Exit(1)
+	}
+	execBotPortal(botId, p)
 
 	os.Exit(0)
 }
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	NotifyFileName = "notify.json"
+
+	NotifierTypeWebhook = "webhook"
+	NotifierTypeEmail   = "email"
+	NotifierTypeStdout  = "stdout"
+
+	NotifyOnAll   = "all"
+	NotifyOnError = "error"
+
+	// streams of the stdout notifier
+	NotifyStreamStdout = "stdout"
+	NotifyStreamStderr = "stderr"
+
+	DefaultNotifyTemplate = "Cloud Bot job {{.JobId}} of {{.BotName}} ({{.BotId}}) finished with {{.Status}}. elapsed time: {{.ElapsedTime}}"
+	DefaultNotifySubject  = "[cbot] {{.BotName}} {{.Status}}"
+
+	DefaultSMTPPort = 25
+)
+
+var (
+	notifiers     []*notifier
+	notifiersErr  error
+	notifiersOnce sync.Once
+)
+
+type notifyFile struct {
+	Notifiers []*notifier `json:"notifiers"`
+}
+
+type notifier struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	On       string   `json:"on"`
+	Bots     []string `json:"bots"`
+	Template string   `json:"template"`
+
+	// stdout. stderr by default not to mix with the results on stdout.
+	Stream string `json:"stream"`
+
+	// webhook
+	URL string `json:"url"`
+
+	// email
+	SMTPHost string   `json:"smtp_host"`
+	SMTPPort int      `json:"smtp_port"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	Subject  string   `json:"subject"`
+}
+
+// notifyData is the data referable from notification templates.
+type notifyData struct {
+	JobId       string
+	BotId       string
+	BotName     string
+	Status      string
+	StartTime   string
+	ElapsedTime int
+	Message     string
+	Output      string
+}
+
+func getNotifyFilePath() string {
+	return filepath.Join(getConfigDir(), NotifyFileName)
+}
+
+func loadNotifiers(path string) ([]*notifier, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("notify file load failed. %v: %v", path, err)
+	}
+
+	var f notifyFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("notify file load failed. %v: %v", path, err)
+	}
+
+	for i, n := range f.Notifiers {
+		if n.Name == "" {
+			n.Name = fmt.Sprintf("#%d", i+1)
+		}
+		switch n.Type {
+		case NotifierTypeWebhook:
+			if n.URL == "" {
+				return nil, fmt.Errorf("notifier '%s' has no url.", n.Name)
+			}
+		case NotifierTypeEmail:
+			if n.SMTPHost == "" || n.From == "" || len(n.To) == 0 {
+				return nil, fmt.Errorf("notifier '%s' needs smtp_host, from and to.", n.Name)
+			}
+			if n.SMTPPort == 0 {
+				n.SMTPPort = DefaultSMTPPort
+			}
+			if n.Subject == "" {
+				n.Subject = DefaultNotifySubject
+			}
+		case NotifierTypeStdout:
+			if n.Stream == "" {
+				n.Stream = NotifyStreamStderr
+			}
+			if n.Stream != NotifyStreamStderr && n.Stream != NotifyStreamStdout {
+				return nil, fmt.Errorf("notifier '%s' stream must be '%s' or '%s'.", n.Name, NotifyStreamStderr, NotifyStreamStdout)
+			}
+		default:
+			return nil, fmt.Errorf("notifier '%s' type must be '%s', '%s' or '%s'.", n.Name, NotifierTypeWebhook, NotifierTypeEmail, NotifierTypeStdout)
+		}
+
+		if n.On == "" {
+			n.On = NotifyOnAll
+		}
+		if n.On != NotifyOnAll && n.On != NotifyOnError {
+			return nil, fmt.Errorf("notifier '%s' on must be '%s' or '%s'.", n.Name, NotifyOnAll, NotifyOnError)
+		}
+		if n.Template == "" {
+			n.Template = DefaultNotifyTemplate
+		}
+	}
+	return f.Notifiers, nil
+}
+
+func getNotifiers() ([]*notifier, error) {
+	notifiersOnce.Do(func() {
+		notifiers, notifiersErr = loadNotifiers(getNotifyFilePath())
+	})
+	return notifiers, notifiersErr
+}
+
+func (n *notifier) match(data *notifyData) bool {
+	if n.On == NotifyOnError && data.Status != jobStatusString(JobStatusError) {
+		return false
+	}
+	if len(n.Bots) == 0 {
+		return true
+	}
+	for _, b := range n.Bots {
+		if b == data.BotId {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *notifier) send(data *notifyData) error {
+	message, err := renderTemplate(n.Name, n.Template, data)
+	if err != nil {
+		return err
+	}
+
+	switch n.Type {
+	case NotifierTypeWebhook:
+		return sendWebhook(n.URL, message)
+	case NotifierTypeEmail:
+		subject, err := renderTemplate(n.Name+".subject", n.Subject, data)
+		if err != nil {
+			return err
+		}
+		return sendEmail(n, subject, message)
+	default:
+		w := os.Stderr
+		if n.Stream == NotifyStreamStdout {
+			w = os.Stdout
+		}
+		fmt.Fprintln(w, message)
+		return nil
+	}
+}
+
+// sendWebhook posts the message as {"text": message} which is
+// accepted by Slack and Teams incoming webhooks.
+func sendWebhook(url string, message string) error {
+	b, err := json.Marshal(map[string]string{"text": message})
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status '%d'.", resp.StatusCode)
+	}
+	return nil
+}
+
+// sanitizeHeader folds the line breaks of the header value, which would
+// add headers of the mail otherwise.
+func sanitizeHeader(v string) string {
+	return strings.Join(strings.FieldsFunc(v, func(c rune) bool { return c == '\r' || c == '\n' }), " ")
+}
+
+func sendEmail(n *notifier, subject string, message string) error {
+	for _, a := range append([]string{n.From}, n.To...) {
+		if strings.ContainsAny(a, "\r\n") {
+			return fmt.Errorf("invalidate address '%s'. It must not have line breaks.", strings.TrimSpace(sanitizeHeader(a)))
+		}
+	}
+	addr := net.JoinHostPort(n.SMTPHost, strconv.Itoa(n.SMTPPort))
+
+	var auth smtp.Auth
+	if n.Username != "" {
+		auth = smtp.PlainAuth("", n.Username, n.Password, n.SMTPHost)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", sanitizeHeader(subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=UTF-8\r\n")
+	fmt.Fprintf(&msg, "\r\n%s\r\n", strings.Replace(message, "\n", "\r\n", -1))
+
+	return smtp.SendMail(addr, auth, n.From, n.To, msg.Bytes())
+}
+
+func newNotifyData(job *showJobResponse) *notifyData {
+	return &notifyData{
+		JobId:       job.JobId,
+		BotId:       job.BotId,
+		BotName:     job.BotName,
+		Status:      job.StatusString(),
+		StartTime:   job.StartTime,
+		ElapsedTime: job.ElapsedTime,
+		Message:     job.Message,
+		Output:      compactOutput(job.Output),
+	}
+}
+
+// notifyJobFinished sends the notifications of the finished job.
+// Failures are reported but never stop the command.
+func notifyJobFinished(job *showJobResponse) {
+	ns, err := getNotifiers()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return
+	}
+
+	sendNotifications(ns, newNotifyData(job))
+}
+
+// sendNotifications sends the data to the matched notifiers.
+func sendNotifications(ns []*notifier, data *notifyData) {
+	for _, n := range ns {
+		if !n.match(data) {
+			continue
+		}
+		if err := n.send(data); err != nil {
+			fmt.Fprintf(os.Stderr, "notification '%s' failed. %v\n", n.Name, err)
+		}
+	}
+}
+
+func notifyCommand(args []string) {
+	var path string
+
+	fs := flag.NewFlagSet("notify", flag.ExitOnError)
+	fs.StringVar(&path, "f", getNotifyFilePath(), "notify file")
+
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, `Usage: cbot-cli notify [OPTION]... test [NAME]
+  Send a sample notification to the notifiers(or the notifier NAME).
+  Options:
+    -f FILE        : notify file.[default ~/.config/cbot/notify.json]
+`)
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.Arg(0) != "test" {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	ns, err := loadNotifiers(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		os.Exit(1)
+	}
+
+	data := &notifyData{
+		JobId:       "TEST_JOB_ID",
+		BotId:       "TEST_BOT_ID",
+		BotName:     "test bot",
+		Status:      jobStatusString(JobStatusError),
+		StartTime:   time.Now().Format("2006-01-02 15:04:05"),
+		ElapsedTime: 0,
+		Message:     "this is a test notification from cbot-cli.",
+	}
+
+	failed := false
+	sent := 0
+	for _, n := range ns {
+		if fs.NArg() > 1 && n.Name != fs.Arg(1) {
+			continue
+		}
+		sent++
+		if err := n.send(data); err != nil {
+			fmt.Fprintf(os.Stderr, "notification '%s' failed. %v\n", n.Name, err)
+			failed = true
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "notification '%s' sent.\n", n.Name)
+	}
+
+	if sent == 0 {
+		fmt.Fprintf(os.Stderr, "no notifiers are found in %s.", path)
+		os.Exit(1)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
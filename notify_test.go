@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func testNotifyData(botId string, status int) *notifyData {
+	return &notifyData{
+		JobId:       "JOB1",
+		BotId:       botId,
+		BotName:     "bot " + botId,
+		Status:      jobStatusString(status),
+		ElapsedTime: 3,
+	}
+}
+
+// webhookRecorder records the texts posted to the webhook.
+type webhookRecorder struct {
+	mu    sync.Mutex
+	texts []string
+}
+
+func (r *webhookRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var body map[string]string
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.mu.Lock()
+	r.texts = append(r.texts, body["text"])
+	r.mu.Unlock()
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	rec := &webhookRecorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	ns := []*notifier{
+		{Name: "all", Type: NotifierTypeWebhook, URL: srv.URL, On: NotifyOnAll, Template: "all {{.BotName}} {{.Status}} {{.ElapsedTime}}"},
+		{Name: "error", Type: NotifierTypeWebhook, URL: srv.URL, On: NotifyOnError, Template: "error {{.BotId}}"},
+		{Name: "bot", Type: NotifierTypeWebhook, URL: srv.URL, On: NotifyOnAll, Bots: []string{"B2"}, Template: "bot {{.JobId}}"},
+	}
+	sendNotifications(ns, testNotifyData("B1", JobStatusExit))
+	sendNotifications(ns, testNotifyData("B1", JobStatusError))
+	sendNotifications(ns, testNotifyData("B2", JobStatusExit))
+
+	want := []string{
+		"all bot B1 exit 3",
+		"all bot B1 error 3",
+		"error B1",
+		"all bot B2 exit 3",
+		"bot JOB1",
+	}
+	if strings.Join(rec.texts, "\n") != strings.Join(want, "\n") {
+		t.Errorf("posted texts = %q, want %q", rec.texts, want)
+	}
+}
+
+func TestWebhookNotifierStatusError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gone", http.StatusGone)
+	}))
+	defer srv.Close()
+
+	n := &notifier{Name: "hook", Type: NotifierTypeWebhook, URL: srv.URL, Template: DefaultNotifyTemplate}
+	if err := n.send(testNotifyData("B1", JobStatusExit)); err == nil {
+		t.Error("send succeeded on status 410")
+	}
+}
+
+// fakeSMTPServer accepts one mail per connection and records its data.
+func fakeSMTPServer(t *testing.T) (host string, port int, mails <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	ch := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, ch)
+		}
+	}()
+
+	addr := l.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, ch
+}
+
+func serveSMTP(conn net.Conn, mails chan<- string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL"), strings.HasPrefix(cmd, "RCPT"):
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			mails <- data.String()
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestEmailNotifier(t *testing.T) {
+	host, port, mails := fakeSMTPServer(t)
+
+	ns := []*notifier{
+		{
+			Name: "mail", Type: NotifierTypeEmail, On: NotifyOnError, Bots: []string{"B1"},
+			SMTPHost: host, SMTPPort: port, From: "cbot@example.com", To: []string{"ops@example.com"},
+			Subject: DefaultNotifySubject, Template: "job {{.JobId}} {{.Status}}",
+		},
+	}
+	sendNotifications(ns, testNotifyData("B1", JobStatusExit))
+	sendNotifications(ns, testNotifyData("B2", JobStatusError))
+	sendNotifications(ns, testNotifyData("B1", JobStatusError))
+
+	var got []string
+	for len(mails) > 0 {
+		got = append(got, <-mails)
+	}
+	if len(got) != 1 {
+		t.Fatalf("%d mails are sent, want 1: %q", len(got), got)
+	}
+	for _, want := range []string{
+		"From: cbot@example.com\r\n",
+		"To: ops@example.com\r\n",
+		"Subject: [cbot] bot B1 error\r\n",
+		"\r\njob JOB1 error\r\n",
+	} {
+		if !strings.Contains(got[0], want) {
+			t.Errorf("mail does not contain %q:\n%s", want, got[0])
+		}
+	}
+}
+
+func TestEmailNotifierHeaderInjection(t *testing.T) {
+	host, port, mails := fakeSMTPServer(t)
+
+	n := &notifier{
+		Name: "mail", Type: NotifierTypeEmail, On: NotifyOnAll,
+		SMTPHost: host, SMTPPort: port, From: "cbot@example.com", To: []string{"ops@example.com"},
+		Subject: DefaultNotifySubject, Template: "job {{.JobId}} {{.Status}}",
+	}
+	data := testNotifyData("B1", JobStatusExit)
+	data.BotName = "bot\r\nBcc: evil@example.com"
+	sendNotifications([]*notifier{n}, data)
+
+	if len(mails) != 1 {
+		t.Fatalf("%d mails are sent, want 1", len(mails))
+	}
+	mail := <-mails
+	if strings.Contains(mail, "\r\nBcc:") {
+		t.Errorf("header is injected:\n%s", mail)
+	}
+	if !strings.Contains(mail, "Subject: [cbot] bot Bcc: evil@example.com exit\r\n") {
+		t.Errorf("subject is not folded:\n%s", mail)
+	}
+
+	n.From = "cbot@example.com\r\nBcc: evil@example.com"
+	if err := sendEmail(n, "subject", "message"); err == nil {
+		t.Errorf("address with line breaks is accepted")
+	}
+	if len(mails) != 0 {
+		t.Errorf("mail is sent with the invalidate address")
+	}
+}
+
+func TestLoadNotifiers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), NotifyFileName)
+	content := `{"notifiers": [
+		{"type": "stdout"},
+		{"name": "mail", "type": "email", "smtp_host": "localhost", "from": "a@example.com", "to": ["b@example.com"]}
+	]}`
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ns, err := loadNotifiers(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ns) != 2 {
+		t.Fatalf("%d notifiers are loaded, want 2", len(ns))
+	}
+	if ns[0].Name != "#1" || ns[0].On != NotifyOnAll || ns[0].Stream != NotifyStreamStderr || ns[0].Template != DefaultNotifyTemplate {
+		t.Errorf("stdout notifier defaults = %+v", ns[0])
+	}
+	if ns[1].SMTPPort != DefaultSMTPPort || ns[1].Subject != DefaultNotifySubject {
+		t.Errorf("email notifier defaults = %+v", ns[1])
+	}
+
+	for _, bad := range []string{
+		`{"notifiers": [{"type": "sms"}]}`,
+		`{"notifiers": [{"type": "webhook"}]}`,
+		`{"notifiers": [{"type": "stdout", "stream": "file"}]}`,
+		`{"notifiers": [{"type": "stdout", "on": "exit"}]}`,
+	} {
+		if err := ioutil.WriteFile(path, []byte(bad), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadNotifiers(path); err == nil {
+			t.Errorf("%s is loaded without errors", bad)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	MaxPickerItems = 20
+)
+
+var (
+	PickCanceledError = errors.New("selection canceled")
+
+	promptReader = bufio.NewReader(os.Stdin)
+)
+
+type pickerItem struct {
+	Value string
+	Label string
+}
+
+// isInteractive reports whether both stdin and stderr are terminals.
+// The picker and prompts are written to stderr so that stdout stays
+// the output of the command.
+func isInteractive() bool {
+	for _, f := range []*os.File{os.Stdin, os.Stderr} {
+		fi, err := f.Stat()
+		if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func readPromptLine(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := promptReader.ReadString('\n')
+	if err != nil && line == "" {
+		fmt.Fprintln(os.Stderr)
+		return "", PickCanceledError
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// fuzzyScore matches the query as a case-insensitive subsequence of s.
+// Smaller score is better. ok is false when the query does not match.
+func fuzzyScore(s string, query string) (int, bool) {
+	s = strings.ToLower(s)
+	query = strings.ToLower(query)
+	if query == "" {
+		return 0, true
+	}
+	if i := strings.Index(s, query); i >= 0 {
+		return i, true
+	}
+
+	first, last := -1, -1
+	pos := 0
+	for _, q := range query {
+		i := strings.IndexRune(s[pos:], q)
+		if i < 0 {
+			return 0, false
+		}
+		if first < 0 {
+			first = pos + i
+		}
+		last = pos + i
+		pos += i + len(string(q))
+	}
+	// gaps are worse than a late substring match
+	return len(s) + last - first, true
+}
+
+func filterPickerItems(items []pickerItem, query string) []pickerItem {
+	type scored struct {
+		item  pickerItem
+		score int
+	}
+	var matches []scored
+	for _, it := range items {
+		if score, ok := fuzzyScore(it.Label, query); ok {
+			matches = append(matches, scored{it, score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].score < matches[j].score
+	})
+
+	ret := make([]pickerItem, len(matches))
+	for i, m := range matches {
+		ret[i] = m.item
+	}
+	return ret
+}
+
+// pick lets the user choose one of items. Typing text narrows the list
+// by fuzzy search, typing a number selects the item and an empty line
+// selects the first item.
+func pick(title string, items []pickerItem) (string, error) {
+	if len(items) == 0 {
+		return "", fmt.Errorf("no %s to select.", title)
+	}
+
+	query := ""
+	for {
+		matches := filterPickerItems(items, query)
+		fmt.Fprintf(os.Stderr, "%s (%d/%d)\n", title, len(matches), len(items))
+		for i, it := range matches {
+			if i >= MaxPickerItems {
+				fmt.Fprintf(os.Stderr, "  ... %d more\n", len(matches)-MaxPickerItems)
+				break
+			}
+			fmt.Fprintf(os.Stderr, "  %2d) %s\n", i+1, it.Label)
+		}
+
+		line, err := readPromptLine(fmt.Sprintf("search [%s] or number, empty for 1: ", query))
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimSpace(line)
+
+		if line == "" && len(matches) > 0 {
+			return matches[0].Value, nil
+		}
+		if n, err := strconv.Atoi(line); err == nil && n >= 1 && n <= len(matches) && n <= MaxPickerItems {
+			return matches[n-1].Value, nil
+		}
+		query = line
+	}
+}
+
+func pickBot(ctx context.Context) (string, error) {
+	bots, _, err := cachedFetchBots(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	items := make([]pickerItem, len(bots.Bots))
+	for i, b := range bots.Bots {
+		items[i] = pickerItem{
+			Value: b.Id,
+			Label: fmt.Sprintf("%s\t%s\t%s\t%s", b.Name, b.Description, b.Creator, b.Id),
+		}
+	}
+	return pick("bots", items)
+}
+
+func pickJob(ctx context.Context, botId string) (string, error) {
+	jobs, _, err := fetchJobs(ctx, botId)
+	if err != nil {
+		return "", err
+	}
+
+	// running jobs first, then the newest
+	list := jobs.Jobs
+	sort.SliceStable(list, func(i, j int) bool {
+		ri := list[i].Status == JobStatusRunning
+		rj := list[j].Status == JobStatusRunning
+		if ri != rj {
+			return ri
+		}
+		return list[i].StartTime > list[j].StartTime
+	})
+
+	items := make([]pickerItem, len(list))
+	for i, j := range list {
+		items[i] = pickerItem{
+			Value: j.JobId,
+			Label: fmt.Sprintf("%s\t%s\t%s", j.StartTime, j.StatusString(), j.JobId),
+		}
+	}
+	return pick("jobs", items)
+}
+
+func exitOnPickError(err error) {
+	if err == UnauthorizedError {
+		fmt.Fprintf(os.Stderr, "unauthorized error returned. Check your access token and key.")
+		os.Exit(1)
+	} else if err == ForbiddenError {
+		fmt.Fprintf(os.Stderr, "forbidden error returned. Do you have a reference authorize?")
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		os.Exit(1)
+	}
+}
+
+// botArgPortal returns the resolved bot of args[0]. When it is omitted
+// on a terminal, the bot is picked interactively. ok is false when the
+// argument is omitted without a terminal.
+func botArgPortal(args []string) (string, bool) {
+	if len(args) > 0 {
+		return resolveBotIdPortal(args[0]), true
+	}
+	if !isInteractive() {
+		return "", false
+	}
+	id, err := pickBot(commandContext())
+	exitOnPickError(err)
+	return id, true
+}
+
+// jobArgPortal is botArgPortal for job ids. The bot is picked first
+// and then one of its jobs.
+func jobArgPortal(args []string) (string, bool) {
+	if len(args) > 0 {
+		return args[0], true
+	}
+	if !isInteractive() {
+		return "", false
+	}
+	botId, err := pickBot(commandContext())
+	exitOnPickError(err)
+	jobId, err := pickJob(commandContext(), botId)
+	exitOnPickError(err)
+	return jobId, true
+}
+
+// promptInput asks the value of each declared input of the bot.
+// An empty answer takes the default, and inputs without a default
+// are left out.
+func promptInput(ctx context.Context, botId string) (map[string]string, error) {
+	bot, _, err := cachedFetchBot(ctx, botId)
+	if err != nil {
+		return nil, err
+	}
+
+	input := make(map[string]string)
+	for _, p := range bot.InputParameters() {
+		prompt := p.Name
+		if p.Description != "" {
+			prompt += " (" + p.Description + ")"
+		}
+		if p.Default != "" {
+			prompt += " [" + p.Default + "]"
+		}
+		v, err := readPromptLine(prompt + ": ")
+		if err != nil {
+			return nil, err
+		}
+		if v == "" {
+			v = p.Default
+		}
+		if v != "" {
+			input[p.Name] = v
+		}
+	}
+	return input, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+const (
+	PluginPrefix = "cbot-cli-"
+
+	// passed to plugins with the resolved profile
+	PluginEnvProfile         = "CBOT_PROFILE"
+	PluginEnvConfig          = "CBOT_CONFIG"
+	PluginEnvApiPath         = "CBOT_API_PATH"
+	PluginEnvAccessToken     = "CBOT_ACCESS_TOKEN"
+	PluginEnvSecretKey       = "CBOT_SECRET_KEY"
+	PluginEnvContentLanguage = "CBOT_CONTENT_LANGUAGE"
+	PluginEnvCli             = "CBOT_CLI"
+)
+
+type plugin struct {
+	Name string
+	Path string
+}
+
+// pluginName returns the command name of the plugin file name. On
+// windows the extension of PATHEXT is removed.
+func pluginName(file string) (string, bool) {
+	if !strings.HasPrefix(file, PluginPrefix) {
+		return "", false
+	}
+	name := strings.TrimPrefix(file, PluginPrefix)
+	if runtime.GOOS == "windows" {
+		ext := strings.ToLower(filepath.Ext(name))
+		if ext == "" || !strings.Contains(strings.ToLower(os.Getenv("PATHEXT")), ext) {
+			return "", false
+		}
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	if name == "" || strings.HasPrefix(name, "-") {
+		return "", false
+	}
+	return name, true
+}
+
+func isExecutable(fi os.FileInfo) bool {
+	if fi.IsDir() {
+		return false
+	}
+	return runtime.GOOS == "windows" || fi.Mode().Perm()&0111 != 0
+}
+
+// listPlugins lists cbot-cli-NAME executables on PATH. A plugin found
+// earlier on PATH hides the later ones like the shell does.
+func listPlugins() []plugin {
+	found := make(map[string]bool)
+	var ret []plugin
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if dir == "" {
+			continue
+		}
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, fi := range files {
+			name, ok := pluginName(fi.Name())
+			if !ok || found[name] {
+				continue
+			}
+			path := filepath.Join(dir, fi.Name())
+			if fi.Mode()&os.ModeSymlink != 0 {
+				if fi, err = os.Stat(path); err != nil {
+					continue
+				}
+			}
+			if !isExecutable(fi) {
+				continue
+			}
+			found[name] = true
+			ret = append(ret, plugin{Name: name, Path: path})
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+func findPlugin(name string) (string, bool) {
+	if name == "" || strings.HasPrefix(name, "-") || strings.ContainsAny(name, `/\`) {
+		return "", false
+	}
+	path, err := exec.LookPath(PluginPrefix + name)
+	if err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+func pluginEnv() []string {
+	env := os.Environ()
+	if exe, err := os.Executable(); err == nil {
+		env = append(env, PluginEnvCli+"="+exe)
+	}
+	return append(env,
+		PluginEnvProfile+"="+currentProfileName(),
+		PluginEnvConfig+"="+getConfigPath(),
+		PluginEnvApiPath+"="+UserConfig.ApiPath,
+		PluginEnvAccessToken+"="+UserConfig.AccessToken,
+		PluginEnvSecretKey+"="+UserConfig.SecretKey,
+		PluginEnvContentLanguage+"="+UserConfig.ContentLanguage,
+	)
+}
+
+// runPlugin runs the plugin with the resolved profile in the
+// environment and exits with its exit code. Ctrl-C is left to the
+// plugin, which is in the same process group.
+func runPlugin(path string, args []string) {
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = pluginEnv()
+
+	signal.Ignore(os.Interrupt)
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		os.Exit(pluginExitCode(exitErr))
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "plugin %s failed. %v", path, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// pluginExitCode is the exit code of the plugin. The plugin killed by
+// a signal exits with 128+signal like shells.
+func pluginExitCode(err *exec.ExitError) int {
+	if ws, ok := err.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	if code := err.ExitCode(); code >= 0 {
+		return code
+	}
+	return 1
+}
+
+// pluginUsage is appended to the help output.
+func pluginUsage() string {
+	plugins := listPlugins()
+	if len(plugins) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("  Plugins:\n")
+	for _, p := range plugins {
+		fmt.Fprintf(&b, "    %-14s : %s\n", p.Name, p.Path)
+	}
+	return b.String()
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultProxyListen = ":8780"
+	ProxyPathPrefix    = "/api"
+	ProxyUsersFileName = "proxy_users.json"
+	ProxyAuditFileName = "proxy_audit.jsonl"
+
+	ProxyOperationRead  = "read"
+	ProxyOperationRun   = "run"
+	ProxyOperationAbort = "abort"
+
+	ProxyAllBots = "*"
+
+	// the API requests are small JSON of the inputs
+	MaxProxyBodySize = 1 << 20
+
+	ProxyReadHeaderTimeout = 10 * time.Second
+	ProxyReadTimeout       = 30 * time.Second
+	// longer than the timeout of the requests to Cloud Bot
+	ProxyWriteTimeout = 90 * time.Second
+)
+
+type proxyUsersFile struct {
+	Users []*proxyUser `json:"users"`
+}
+
+// proxyUser is a user of the proxy. The token is given as it is or as
+// the hex SHA-256 of it. (see cbot-cli proxy token)
+type proxyUser struct {
+	Name        string   `json:"name"`
+	Token       string   `json:"token"`
+	TokenSHA256 string   `json:"token_sha256"`
+	Bots        []string `json:"bots"`
+	Operations  []string `json:"operations"`
+
+	tokenHash []byte
+	bots      map[string]bool
+	ops       map[string]bool
+}
+
+func (u *proxyUser) allowBot(botId string) bool {
+	return u.bots[ProxyAllBots] || u.bots[botId]
+}
+
+type proxyAuditEntry struct {
+	Time      time.Time `json:"time"`
+	User      string    `json:"user"`
+	Remote    string    `json:"remote"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Operation string    `json:"operation,omitempty"`
+	BotId     string    `json:"bot_id,omitempty"`
+	JobId     string    `json:"job_id,omitempty"`
+	Allowed   bool      `json:"allowed"`
+	Status    int       `json:"status"`
+	Error     string    `json:"error,omitempty"`
+}
+
+type proxyServer struct {
+	users  []*proxyUser
+	logger *log.Logger
+	client *http.Client
+
+	mu    sync.Mutex
+	audit *os.File
+}
+
+func getProxyUsersPath() string {
+	return filepath.Join(getConfigDir(), ProxyUsersFileName)
+}
+
+func getProxyAuditPath() string {
+	return filepath.Join(getConfigDir(), ProxyAuditFileName)
+}
+
+func proxyCommand(args []string) {
+	if len(args) > 0 && args[0] == "token" {
+		proxyTokenCommand()
+		return
+	}
+
+	var listen string
+	var usersPath string
+	var auditPath string
+	var certFile string
+	var keyFile string
+
+	fs := flag.NewFlagSet("proxy", flag.ExitOnError)
+	fs.StringVar(&listen, "listen", DefaultProxyListen, "listen address")
+	fs.StringVar(&usersPath, "users", getProxyUsersPath(), "users file")
+	fs.StringVar(&auditPath, "audit", getProxyAuditPath(), "audit log file")
+	fs.StringVar(&certFile, "tls-cert", "", "tls certificate file")
+	fs.StringVar(&keyFile, "tls-key", "", "tls key file")
+
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, `Usage: cbot-cli proxy [OPTION]...
+       cbot-cli proxy token
+  Serve the Cloud Bot API on /api with the credentials of the current profile.
+  Users authenticate with their own token as the access token, so their
+  ApiPath is http(s)://HOST:PORT/api and SecretKey is any text.
+  'proxy token' generates a token and its token_sha256 for the users file.
+  Options:
+    -listen ADDRESS : listen address.[default ':8780']
+    -users FILE     : users file.[default ~/.config/cbot/proxy_users.json]
+    -audit FILE     : audit log file.[default ~/.config/cbot/proxy_audit.jsonl]
+    -tls-cert FILE  : serve https with the certificate.
+    -tls-key FILE   : key of the certificate.
+  Without -tls-cert, the tokens of the users are sent in plain HTTP.
+  Use TLS unless the proxy is reachable only from trusted networks.
+  Users file:
+    {"users": [{"name": "alice", "token_sha256": "...",
+                "bots": ["BOT", ...] or ["*"], "operations": ["read", "run", "abort"]}]}
+`)
+	}
+	fs.Parse(args)
+
+	users, err := loadProxyUsers(usersPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		os.Exit(1)
+	}
+
+	audit, err := os.OpenFile(auditPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "audit log open failed. %v", err)
+		os.Exit(1)
+	}
+	defer audit.Close()
+
+	p := &proxyServer{
+		users:  users,
+		logger: log.New(os.Stderr, "", log.LstdFlags),
+		client: &http.Client{Timeout: 60 * time.Second},
+		audit:  audit,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(ProxyPathPrefix+"/", p.serve)
+	server := &http.Server{
+		Addr:              listen,
+		Handler:           mux,
+		ReadHeaderTimeout: ProxyReadHeaderTimeout,
+		ReadTimeout:       ProxyReadTimeout,
+		WriteTimeout:      ProxyWriteTimeout,
+	}
+
+	p.logger.Printf("serving the API proxy on %s%s for %d users", listen, ProxyPathPrefix, len(users))
+	if certFile != "" {
+		err = server.ListenAndServeTLS(certFile, keyFile)
+	} else {
+		p.logger.Printf("warning: TLS is not enabled. The tokens are sent in plain HTTP. Use -tls-cert and -tls-key.")
+		err = server.ListenAndServe()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		os.Exit(1)
+	}
+}
+
+func proxyTokenCommand() {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		os.Exit(1)
+	}
+	token := hex.EncodeToString(b)
+	sum := sha256.Sum256([]byte(token))
+	fmt.Printf("token        : %s\n", token)
+	fmt.Printf("token_sha256 : %s\n", hex.EncodeToString(sum[:]))
+}
+
+func loadProxyUsers(path string) ([]*proxyUser, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("users file load failed. %v", err)
+	}
+
+	var f proxyUsersFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("users file load failed. %v: %v", path, err)
+	}
+	if len(f.Users) == 0 {
+		return nil, fmt.Errorf("no users are defined in %s.", path)
+	}
+
+	names := make(map[string]bool)
+	for _, u := range f.Users {
+		if u.Name == "" || names[u.Name] {
+			return nil, fmt.Errorf("user name '%s' is empty or duplicated.", u.Name)
+		}
+		names[u.Name] = true
+
+		switch {
+		case u.TokenSHA256 != "":
+			u.tokenHash, err = hex.DecodeString(u.TokenSHA256)
+			if err != nil || len(u.tokenHash) != sha256.Size {
+				return nil, fmt.Errorf("user '%s' has invalid token_sha256.", u.Name)
+			}
+		case u.Token != "":
+			sum := sha256.Sum256([]byte(u.Token))
+			u.tokenHash = sum[:]
+		default:
+			return nil, fmt.Errorf("user '%s' has no token.", u.Name)
+		}
+
+		u.ops = make(map[string]bool)
+		for _, op := range u.Operations {
+			switch op {
+			case ProxyOperationRead, ProxyOperationRun, ProxyOperationAbort:
+				u.ops[op] = true
+			default:
+				return nil, fmt.Errorf("user '%s' has unknown operation '%s'. Operations are read, run and abort.", u.Name, op)
+			}
+		}
+
+		u.bots = make(map[string]bool)
+		for _, ref := range u.Bots {
+			if ref == ProxyAllBots {
+				u.bots[ref] = true
+				continue
+			}
+			id, err := resolveBotId(commandContext(), ref)
+			if err != nil {
+				return nil, fmt.Errorf("user '%s' bot '%s': %v", u.Name, ref, err)
+			}
+			u.bots[id] = true
+		}
+	}
+	return f.Users, nil
+}
+
+func (p *proxyServer) authenticate(r *http.Request) *proxyUser {
+	token := r.Header.Get("access-token")
+	if token == "" {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(token))
+	for _, u := range p.users {
+		if subtle.ConstantTimeCompare(sum[:], u.tokenHash) == 1 {
+			return u
+		}
+	}
+	return nil
+}
+
+func (p *proxyServer) writeAudit(e *proxyAuditEntry) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, err := p.audit.Write(append(b, '\n')); err != nil {
+		p.logger.Printf("audit log write failed. %v", err)
+	}
+	p.logger.Printf("%s %s %s %s allowed=%v status=%d", e.User, e.Remote, e.Method, e.Path, e.Allowed, e.Status)
+}
+
+// writeCode answers in the form of the API, {"code": N}.
+func writeCode(w http.ResponseWriter, code int) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(code)
+	fmt.Fprintf(w, `{"code": %d}`, code)
+}
+
+// forward sends the request to Cloud Bot with the real credentials.
+func (p *proxyServer) forward(ctx context.Context, method string, rest string, rawQuery string, body []byte) (int, []byte, error) {
+	u, err := url.Parse(UserConfig.ApiPath)
+	if err != nil {
+		return 0, nil, err
+	}
+	u.Path = path.Join(u.Path, rest)
+	u.RawQuery = rawQuery
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Add("content-type", "application/json")
+	req.Header.Add("content-language", UserConfig.ContentLanguage)
+	req.Header.Add("access-token", UserConfig.AccessToken)
+	req.Header.Add("secret-key", UserConfig.SecretKey)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, b, nil
+}
+
+// jobBotId returns the bot id of the job answered by Cloud Bot.
+func jobBotId(body []byte) (string, int) {
+	var ret showJobResponse
+	if err := json.Unmarshal(body, &ret); err != nil {
+		return "", 0
+	}
+	return ret.BotId, ret.Code
+}
+
+// filterBots removes the bots not allowed to the user from the listing.
+func filterBots(body []byte, u *proxyUser) ([]byte, error) {
+	var ret map[string]json.RawMessage
+	if err := json.Unmarshal(body, &ret); err != nil {
+		return nil, err
+	}
+	var bots []json.RawMessage
+	if err := json.Unmarshal(ret["bots"], &bots); err != nil {
+		// error responses have no bots
+		return body, nil
+	}
+
+	allowed := []json.RawMessage{}
+	for _, b := range bots {
+		var bot listingBotsResponseBot
+		if err := json.Unmarshal(b, &bot); err == nil && u.allowBot(bot.Id) {
+			allowed = append(allowed, b)
+		}
+	}
+	b, err := json.Marshal(allowed)
+	if err != nil {
+		return nil, err
+	}
+	ret["bots"] = b
+	return json.Marshal(ret)
+}
+
+// deny answers 403 for the request denied by the profile protection.
+func (p *proxyServer) deny(w http.ResponseWriter, e *proxyAuditEntry, err error) {
+	e.Allowed = false
+	e.Error = err.Error()
+	e.Status = http.StatusForbidden
+	writeCode(w, e.Status)
+}
+
+func (p *proxyServer) serve(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, ProxyPathPrefix), "/")
+	parts := strings.Split(rest, "/")
+
+	ctx, cancel := requestContext(r.Context())
+	defer cancel()
+
+	e := &proxyAuditEntry{
+		Time:   time.Now(),
+		Remote: r.RemoteAddr,
+		Method: r.Method,
+		Path:   r.URL.Path,
+	}
+	defer p.writeAudit(e)
+
+	u := p.authenticate(r)
+	if u == nil {
+		e.Status = http.StatusUnauthorized
+		writeCode(w, e.Status)
+		return
+	}
+	e.User = u.Name
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MaxProxyBodySize))
+	if err != nil {
+		e.Status = http.StatusBadRequest
+		if len(body) >= MaxProxyBodySize {
+			e.Status = http.StatusRequestEntityTooLarge
+		}
+		writeCode(w, e.Status)
+		return
+	}
+
+	switch {
+	case r.Method == "GET" && len(parts) == 1 && parts[0] == "bots":
+		e.Operation = ProxyOperationRead
+	case r.Method == "GET" && len(parts) == 2 && parts[0] == "bots":
+		e.Operation, e.BotId = ProxyOperationRead, parts[1]
+	case r.Method == "GET" && len(parts) == 3 && parts[0] == "bots" && parts[2] == "jobs":
+		e.Operation, e.BotId = ProxyOperationRead, parts[1]
+	case r.Method == "POST" && len(parts) == 3 && parts[0] == "bots" && parts[2] == "jobs":
+		e.Operation, e.BotId = ProxyOperationRun, parts[1]
+	case r.Method == "GET" && len(parts) == 2 && parts[0] == "jobs":
+		e.Operation, e.JobId = ProxyOperationRead, parts[1]
+	case r.Method == "DELETE" && len(parts) == 2 && parts[0] == "jobs":
+		e.Operation, e.JobId = ProxyOperationAbort, parts[1]
+	default:
+		e.Status = http.StatusNotFound
+		writeCode(w, e.Status)
+		return
+	}
+
+	if !u.ops[e.Operation] || (e.BotId != "" && !u.allowBot(e.BotId)) {
+		e.Status = http.StatusForbidden
+		writeCode(w, e.Status)
+		return
+	}
+
+	e.Allowed = true
+
+	var status int
+	var resp []byte
+	if e.JobId != "" {
+		// the bot of the job is known only from Cloud Bot
+		status, resp, err = p.forward(ctx, "GET", path.Join("jobs", e.JobId), r.URL.RawQuery, nil)
+		if err == nil {
+			botId, code := jobBotId(resp)
+			e.BotId = botId
+			if code == 200 && !u.allowBot(botId) {
+				e.Allowed = false
+				e.Status = http.StatusForbidden
+				writeCode(w, e.Status)
+				return
+			}
+			if code == 200 && r.Method == "DELETE" {
+				if err := guardProxyMutation(ctx, botId); err != nil {
+					p.deny(w, e, err)
+					return
+				}
+				status, resp, err = p.forward(ctx, r.Method, rest, r.URL.RawQuery, body)
+			}
+		}
+	} else {
+		if r.Method == "POST" {
+			if err := guardProxyMutation(ctx, e.BotId); err != nil {
+				p.deny(w, e, err)
+				return
+			}
+		}
+		status, resp, err = p.forward(ctx, r.Method, rest, r.URL.RawQuery, body)
+		if err == nil && e.BotId == "" {
+			resp, err = filterBots(resp, u)
+		}
+	}
+	if err != nil {
+		e.Error = err.Error()
+		e.Status = http.StatusBadGateway
+		writeCode(w, e.Status)
+		return
+	}
+
+	e.Status = status
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	w.Write(resp)
+}
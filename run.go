@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+func runCommand(args []string) {
+	var timeoutTime int
+	var callbackEndpoint string
+	var callbackTries int
+	var execInputParam string
+	var doWait bool
+	var waitInterval int
+	var batchFile string
+	var resultFile string
+	var columnMap string
+	var concurrency int
+	var rate float64
+	var ifIdle bool
+	var onRunning string
+
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	fs.IntVar(&timeoutTime, "t", 0, "timeout time")
+	fs.StringVar(&callbackEndpoint, "u", "", "callback endpoint url")
+	fs.IntVar(&callbackTries, "T", 0, "number of callback retry trials")
+	fs.StringVar(&execInputParam, "i", "", "input parameters for execute bot")
+	fs.BoolVar(&doWait, "wait", false, "wait for the job to finish")
+	fs.IntVar(&waitInterval, "interval", int(DefaultWaitInterval/time.Second), "polling interval seconds while waiting")
+	fs.StringVar(&batchFile, "batch", "", "csv or ndjson file of inputs")
+	fs.StringVar(&resultFile, "result", "", "batch result file")
+	fs.StringVar(&columnMap, "map", "", "batch column to input key mapping")
+	fs.IntVar(&concurrency, "c", 1, "number of concurrent batch submissions")
+	fs.Float64Var(&rate, "rate", 1, "maximum batch submissions per second")
+	fs.BoolVar(&ifIdle, "if-idle", false, "start only when no job of the bot is running")
+	fs.BoolVar(&ifIdle, "single-instance", false, "same as -if-idle")
+	fs.StringVar(&onRunning, "on-running", OnRunningSkip, "skip, wait or abort when the bot is running")
+
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, `Usage: cbot-cli run [OPTION]... [BOT]
+  On a terminal, BOT is picked from a list when omitted and the declared
+  inputs are asked when -i is omitted.
+  Options:
+    -i                : input parameters for execute bot.(ex: key:value,key2:value2...)[default '']
+    -t                : timeout time at bot execution.(0-25000)[default 0]
+    -u                : callback endpoint url.(needs prefix https://)[default '']
+    -T                : number of callback retry trials.(0-5)[default 0]
+    -wait             : wait for the job to finish and print its result.
+    -interval SECONDS : polling interval while waiting.[default 5]
+  Single instance options:
+    -if-idle          : start only when no job of the bot is running.(alias -single-instance)
+                        Concurrent invocations on this host are serialized by a lock.
+    -on-running MODE  : skip, wait or abort the running jobs first.[default skip]
+                        skip exits with 0 without starting the bot.
+  Batch options:
+    -batch FILE       : run the bot once per record of FILE.(.csv or .ndjson)
+                        -i gives the default inputs of the records.
+    -map MAPPING      : column to input key mapping.(ex: column:key,column2:key2...)[default: same name]
+    -result FILE      : result file. Rows already recorded are skipped on rerun.[default FILE.result.csv]
+    -c NUMBER         : number of concurrent submissions.[default 1]
+    -rate NUMBER      : maximum submissions per second.(up to 100)[default 1]
+`)
+	}
+
+	fs.Parse(args)
+	interval := time.Duration(waitInterval) * time.Second
+	if err := validateInterval(interval); err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		os.Exit(1)
+	}
+	botId, ok := botArgPortal(fs.Args())
+	if !ok {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	p := execParameter{
+		TimeoutTime:      timeoutTime,
+		CallbackEndpoint: callbackEndpoint,
+		CallbackTries:    callbackTries,
+		execInputParam:   execInputParam,
+	}
+	ctx := commandContext()
+
+	if execInputParam == "" && batchFile == "" && isInteractive() {
+		input, err := promptInput(ctx, botId)
+		if err == PickCanceledError {
+			fmt.Fprintf(os.Stderr, "%v", err)
+			os.Exit(1)
+		} else if err != nil {
+			// the token may run bots which it can not read
+			fmt.Fprintf(os.Stderr, "reading the bot definition failed. The bot runs without the input. %v\n", err)
+		} else {
+			p.Input = input
+		}
+	}
+
+	if batchFile != "" {
+		if ifIdle {
+			fmt.Fprintf(os.Stderr, "-if-idle can not be used with -batch.")
+			os.Exit(1)
+		}
+		opt := batchOption{
+			File:        batchFile,
+			ResultFile:  resultFile,
+			ColumnMap:   columnMap,
+			Concurrency: concurrency,
+			Rate:        rate,
+			Wait:        doWait,
+			Interval:    interval,
+		}
+		batchPortal(botId, p, opt)
+		return
+	}
+
+	if !doWait && !ifIdle {
+		execBotPortal(botId, p)
+		return
+	}
+
+	err := setupParameter(&p)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "parameter format is invalidate. Ex: key:value")
+		os.Exit(1)
+	}
+	if err := validateInput(ctx, botId, p.Input); err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		os.Exit(1)
+	}
+
+	var lock *fileLock
+	if ifIdle {
+		lock, err = acquireIdleBot(ctx, botId, onRunning, interval)
+		if err == BotStartSkippedError {
+			return
+		}
+		exitOnExecBotError(botId, err)
+	}
+
+	err = execRun(ctx, botId, p, doWait, interval, lock)
+	if err == JobFailedError {
+		// the job result is already printed
+		os.Exit(1)
+	} else if err == InterruptedError {
+		os.Exit(InterruptExitCode)
+	} else if err == JobNotFoundError {
+		fmt.Fprintf(os.Stderr, "submitted job of bot id '%s' is not found.", botId)
+		os.Exit(1)
+	}
+	exitOnExecBotError(botId, err)
+}
+
+// execRun submits the job and waits for it when wait is set. The lock
+// of the bot is released right after the submission.
+// Ctrl-C while waiting offers to abort the job.
+func execRun(ctx context.Context, botId string, param execParameter, wait bool, interval time.Duration, lock *fileLock) error {
+	var f *jobFollower
+	if wait {
+		// Ctrl-C is handled from the submission
+		f = newJobFollower(ctx)
+		defer f.Stop()
+	}
+
+	ret, body, err := runBot(ctx, botId, param)
+	if lock != nil {
+		lock.Unlock()
+	}
+	if err != nil {
+		return err
+	}
+
+	if !wait {
+		fmt.Println(string(body))
+		return nil
+	}
+
+	job, err := f.waitJob(ret.JobId, interval)
+	if err != nil {
+		return err
+	}
+
+	return printJobResult(job)
+}
+
+// printJobResult prints the finished job and returns JobFailedError
+// when the job finished with error.
+func printJobResult(job *showJobResponse) error {
+	b, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+
+	if job.Status == JobStatusError {
+		return JobFailedError
+	}
+	return nil
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	ScheduleFileName      = "schedule.json"
+	ScheduleStateFileName = "schedule_state.json"
+
+	OverlapSkip  = "skip"
+	OverlapAllow = "allow"
+
+	DefaultNextCount = 10
+)
+
+type scheduleFile struct {
+	Schedules []*scheduleEntry `json:"schedules"`
+}
+
+type scheduleEntry struct {
+	Name             string            `json:"name"`
+	Cron             string            `json:"cron"`
+	BotId            string            `json:"bot_id"`
+	Input            map[string]string `json:"input"`
+	TimeoutTime      int               `json:"timeout_time"`
+	CallbackEndpoint string            `json:"callback_endpoint"`
+	CallbackTries    int               `json:"callback_tries"`
+	Overlap          string            `json:"overlap"`
+	CatchUp          bool              `json:"catch_up"`
+	Jitter           int               `json:"jitter"`
+
+	schedule *cronSchedule
+}
+
+type scheduleState struct {
+	LastRun    time.Time `json:"last_run"`
+	LastJobId  string    `json:"last_job_id"`
+	LastResult string    `json:"last_result"`
+}
+
+type scheduler struct {
+	entries   []*scheduleEntry
+	statePath string
+	logger    *log.Logger
+
+	mu    sync.Mutex
+	state map[string]scheduleState
+}
+
+func getScheduleFilePath() string {
+	return filepath.Join(getConfigDir(), ScheduleFileName)
+}
+
+// getScheduleStatePath returns the state file of the current profile.
+// The default profile keeps the file name of the older versions.
+func getScheduleStatePath() string {
+	name := ScheduleStateFileName
+	if profile := currentProfileName(); profile != DefaultProfileName {
+		ext := filepath.Ext(name)
+		name = strings.TrimSuffix(name, ext) + "." + profile + ext
+	}
+	return filepath.Join(getConfigDir(), name)
+}
+
+func schedulerCommand(args []string) {
+	var scheduleFilePath string
+	var statePath string
+	var nextCount int
+
+	fs := flag.NewFlagSet("scheduler", flag.ExitOnError)
+	fs.StringVar(&scheduleFilePath, "f", getScheduleFilePath(), "schedule file")
+	fs.StringVar(&statePath, "state", getScheduleStatePath(), "schedule state file")
+	fs.IntVar(&nextCount, "n", DefaultNextCount, "number of upcoming runs to preview")
+
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, `Usage: cbot-cli scheduler [OPTION]... [run | list | next]
+  Commands:
+    run            : trigger bot runs as scheduled until interrupted.[default]
+    list           : listing schedules with the last and the next run time.
+    next           : preview upcoming runs.
+  Options:
+    -f FILE        : schedule file.[default ~/.config/cbot/schedule.json]
+    -state FILE    : schedule state file.[default ~/.config/cbot/schedule_state.json,
+                     schedule_state.PROFILE.json for the other profiles]
+    -n NUMBER      : number of upcoming runs to preview.[default 10]
+`)
+	}
+
+	fs.Parse(args)
+	action := "run"
+	if fs.NArg() > 0 {
+		action = fs.Arg(0)
+	}
+
+	s, err := loadScheduler(scheduleFilePath, statePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		os.Exit(1)
+	}
+
+	switch action {
+	case "run":
+		if err := startBulkOperation(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v", err)
+			os.Exit(1)
+		}
+		s.Run()
+	case "list":
+		s.List(time.Now())
+	case "next":
+		s.PreviewNext(time.Now(), nextCount)
+	default:
+		fs.Usage()
+		os.Exit(1)
+	}
+}
+
+func loadScheduleFile(path string) ([]*scheduleEntry, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("schedule file load failed. %v: %v", path, err)
+	}
+
+	var f scheduleFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("schedule file load failed. %v: %v", path, err)
+	}
+
+	names := make(map[string]bool)
+	for i, e := range f.Schedules {
+		if e.Name == "" {
+			return nil, fmt.Errorf("schedule #%d has no name.", i+1)
+		}
+		if names[e.Name] {
+			return nil, fmt.Errorf("schedule name '%s' is duplicated.", e.Name)
+		}
+		names[e.Name] = true
+
+		if e.BotId == "" {
+			return nil, fmt.Errorf("schedule '%s' has no bot_id.", e.Name)
+		}
+		if e.Overlap == "" {
+			e.Overlap = OverlapSkip
+		}
+		if e.Overlap != OverlapSkip && e.Overlap != OverlapAllow {
+			return nil, fmt.Errorf("schedule '%s' overlap must be '%s' or '%s'.", e.Name, OverlapSkip, OverlapAllow)
+		}
+		if e.Jitter < 0 {
+			return nil, fmt.Errorf("schedule '%s' jitter must be 0 or more.", e.Name)
+		}
+
+		e.schedule, err = parseCron(e.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("schedule '%s': %v", e.Name, err)
+		}
+	}
+	return f.Schedules, nil
+}
+
+func loadScheduleState(path string) (map[string]scheduleState, error) {
+	state := make(map[string]scheduleState)
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("schedule state load failed. %v: %v", path, err)
+	}
+
+	if err := json.Unmarshal(b, &state); err != nil {
+		return nil, fmt.Errorf("schedule state load failed. %v: %v", path, err)
+	}
+	return state, nil
+}
+
+func loadScheduler(scheduleFilePath string, statePath string) (*scheduler, error) {
+	entries, err := loadScheduleFile(scheduleFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := loadScheduleState(statePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &scheduler{
+		entries:   entries,
+		statePath: statePath,
+		logger:    log.New(os.Stdout, "", log.LstdFlags),
+		state:     state,
+	}, nil
+}
+
+func (s *scheduler) saveState(name string, st scheduleState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.state[name] = st
+	b, err := json.MarshalIndent(s.state, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(s.statePath, b, 0600)
+	}
+	if err != nil {
+		s.logger.Printf("schedule state save failed. %v: %v", s.statePath, err)
+	}
+}
+
+func (s *scheduler) lastRun(name string) (scheduleState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.state[name]
+	return st, ok
+}
+
+func isBotRunning(ctx context.Context, botId string) (bool, error) {
+	jobs, err := runningJobs(ctx, botId)
+	if err != nil {
+		return false, err
+	}
+	return len(jobs) > 0, nil
+}
+
+// trigger runs the schedule. The runs still waiting for the jitter are
+// dropped when stop is done, and the submissions in flight complete.
+func (s *scheduler) trigger(stop context.Context, e *scheduleEntry, scheduled time.Time) {
+	if e.Jitter > 0 {
+		if err := sleepContext(stop, time.Duration(rand.Int63n(int64(e.Jitter)*int64(time.Second)+1))); err != nil {
+			return
+		}
+	}
+
+	st := scheduleState{LastRun: scheduled}
+
+	ctx, cancel := requestContext(context.Background())
+	defer cancel()
+
+	botId, err := resolveBotId(ctx, e.BotId)
+	if err != nil {
+		st.LastResult = fmt.Sprintf("failed: %v", err)
+		s.logger.Printf("%s: bot '%s' resolve failed. %v", e.Name, e.BotId, err)
+		s.saveState(e.Name, st)
+		return
+	}
+
+	if e.Overlap == OverlapSkip {
+		running, err := isBotRunning(ctx, botId)
+		if err != nil {
+			st.LastResult = fmt.Sprintf("failed: %v", err)
+			s.logger.Printf("%s: overlap check of bot '%s' failed. %v", e.Name, e.BotId, err)
+			s.saveState(e.Name, st)
+			return
+		}
+		if running {
+			st.LastResult = "skipped"
+			s.logger.Printf("%s: bot '%s' is still running. skipped.", e.Name, e.BotId)
+			s.saveState(e.Name, st)
+			return
+		}
+	}
+
+	param := execParameter{
+		TimeoutTime:      e.TimeoutTime,
+		CallbackEndpoint: e.CallbackEndpoint,
+		CallbackTries:    e.CallbackTries,
+		Input:            e.Input,
+	}
+	if param.Input == nil {
+		param.Input = make(map[string]string)
+	}
+
+	ret, _, err := runBot(ctx, botId, param)
+	if err != nil {
+		st.LastResult = fmt.Sprintf("failed: %v", err)
+		s.logger.Printf("%s: bot '%s' execution failed. %v", e.Name, e.BotId, err)
+		s.saveState(e.Name, st)
+		return
+	}
+
+	st.LastJobId = ret.JobId
+	st.LastResult = "started"
+	s.logger.Printf("%s: bot '%s' started. job id '%s'", e.Name, e.BotId, ret.JobId)
+	s.saveState(e.Name, st)
+}
+
+// latestMissedRun returns the last scheduled time after the last run
+// and not after now. Missed runs are caught up only once.
+func latestMissedRun(schedule *cronSchedule, lastRun time.Time, now time.Time) time.Time {
+	var missed time.Time
+	for t := schedule.Next(lastRun); !t.IsZero() && !t.After(now); t = schedule.Next(t) {
+		missed = t
+	}
+	return missed
+}
+
+// Run triggers the schedules until the process is interrupted. On
+// SIGINT or SIGTERM, it stops scheduling and waits for the submissions
+// in flight. A second signal exits immediately.
+func (s *scheduler) Run() {
+	if len(s.entries) == 0 {
+		s.logger.Printf("no schedules.")
+		return
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	stop, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	trigger := func(e *scheduleEntry, t time.Time) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.trigger(stop, e, t)
+		}()
+	}
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	now := time.Now()
+	next := make(map[string]time.Time)
+	for _, e := range s.entries {
+		if st, ok := s.lastRun(e.Name); ok && e.CatchUp {
+			missed := latestMissedRun(e.schedule, st.LastRun, now)
+			if !missed.IsZero() {
+				s.logger.Printf("%s: catching up the missed run at %s.", e.Name, missed.Format(time.RFC3339))
+				trigger(e, missed)
+			}
+		}
+		next[e.Name] = e.schedule.Next(now)
+	}
+
+	for {
+		var earliest time.Time
+		for _, t := range next {
+			if !t.IsZero() && (earliest.IsZero() || t.Before(earliest)) {
+				earliest = t
+			}
+		}
+		if earliest.IsZero() {
+			s.logger.Printf("no more scheduled runs.")
+			return
+		}
+
+		timer := time.NewTimer(time.Until(earliest))
+		select {
+		case <-sig:
+			timer.Stop()
+			s.logger.Printf("stopping the scheduler. waiting for the runs in flight...")
+			signal.Stop(sig)
+			return
+		case <-timer.C:
+		}
+
+		for _, e := range s.entries {
+			t := next[e.Name]
+			if t.IsZero() || t.After(earliest) {
+				continue
+			}
+			trigger(e, t)
+			next[e.Name] = e.schedule.Next(t)
+		}
+	}
+}
+
+func formatScheduleTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format("2006-01-02 15:04")
+}
+
+func (s *scheduler) List(now time.Time) {
+	fmt.Println("name\tcron\tbot_id\toverlap\tlast_run\tlast_result\tnext_run")
+	for _, e := range s.entries {
+		st, _ := s.lastRun(e.Name)
+		fmt.Printf("%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.Name, e.Cron, e.BotId, e.Overlap, formatScheduleTime(st.LastRun), st.LastResult, formatScheduleTime(e.schedule.Next(now)))
+	}
+}
+
+type scheduledRun struct {
+	Time  time.Time
+	Entry *scheduleEntry
+}
+
+func upcomingRuns(entries []*scheduleEntry, now time.Time, count int) []scheduledRun {
+	var runs []scheduledRun
+	for _, e := range entries {
+		t := now
+		for i := 0; i < count; i++ {
+			t = e.schedule.Next(t)
+			if t.IsZero() {
+				break
+			}
+			runs = append(runs, scheduledRun{Time: t, Entry: e})
+		}
+	}
+
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].Time.Before(runs[j].Time)
+	})
+	if len(runs) > count {
+		runs = runs[:count]
+	}
+	return runs
+}
+
+func (s *scheduler) PreviewNext(now time.Time, count int) {
+	fmt.Println("time\tname\tbot_id")
+	for _, r := range upcomingRuns(s.entries, now, count) {
+		fmt.Printf("%s\t%s\t%s\n", formatScheduleTime(r.Time), r.Entry.Name, r.Entry.BotId)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -8,20 +9,66 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"sort"
 )
 
 type showBotResponse struct {
-	Code         int    `json:"code"`
-	Id           string `json:"id"`
-	Name         string `json:"name"`
-	Description  string `json:"description"`
-	Created      string `json:"created"`
-	LastModified string `json:"last_modified"`
-	Creator      string `json:"creator"`
+	Code         int             `json:"code"`
+	Id           string          `json:"id"`
+	Name         string          `json:"name"`
+	Description  string          `json:"description"`
+	Created      string          `json:"created"`
+	LastModified string          `json:"last_modified"`
+	Creator      string          `json:"creator"`
+	Input        json.RawMessage `json:"input"`
+	Output       json.RawMessage `json:"output"`
+}
+
+type botParameter struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Default     string `json:"default,omitempty"`
+}
+
+// parseBotParameters reads the input or output definitions of the bot.
+// Both a list of parameters and an object keyed by the parameter name
+// are accepted.
+func parseBotParameters(raw json.RawMessage) []botParameter {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	var list []botParameter
+	if err := json.Unmarshal(raw, &list); err == nil {
+		return list
+	}
+
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return nil
+	}
+	for name, v := range obj {
+		p := botParameter{Name: name}
+		json.Unmarshal(v, &p)
+		p.Name = name
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+func (r *showBotResponse) InputParameters() []botParameter {
+	return parseBotParameters(r.Input)
+}
+
+func (r *showBotResponse) OutputParameters() []botParameter {
+	return parseBotParameters(r.Output)
 }
 
 func showBotPortal(botId string) {
-	err := execShowBot(botId)
+	err := execShowBot(commandContext(), botId)
 	if err == UnauthorizedError {
 		fmt.Fprintf(os.Stderr, "unauthorized error returned. Check your access token and key.")
 		os.Exit(1)
@@ -52,13 +99,13 @@ func buildShowBotURL(botId string) (string, error) {
 	return u.String(), nil
 }
 
-func buildShowBotRequest(botId string) (*http.Request, error) {
+func buildShowBotRequest(ctx context.Context, botId string) (*http.Request, error) {
 	url, err := buildShowBotURL(botId)
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -71,16 +118,16 @@ func buildShowBotRequest(botId string) (*http.Request, error) {
 	return req, nil
 }
 
-func processShowBotResponse(resp *http.Response) error {
+func processShowBotResponse(resp *http.Response) (*showBotResponse, []byte, error) {
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	var ret showBotResponse
 	err = json.Unmarshal(body, &ret)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	switch ret.Code {
@@ -88,40 +135,46 @@ func processShowBotResponse(resp *http.Response) error {
 		// nothing todo
 	case 401:
 		// unauthorized
-		return UnauthorizedError
+		return nil, nil, UnauthorizedError
 	case 403:
 		// forbidden
-		return ForbiddenError
+		return nil, nil, ForbiddenError
 	case 404:
 		// not found
-		return BotNotFoundError
+		return nil, nil, BotNotFoundError
 	default:
 		// error
-		return fmt.Errorf("response code '%d' returned.", ret.Code)
+		return nil, nil, &responseCodeError{Code: ret.Code}
 	}
 
-	fmt.Println(string(body))
-
-	return nil
+	return &ret, body, nil
 }
 
-func execShowBot(botId string) error {
+// fetchBot returns the bot detail together with the raw response body.
+func fetchBot(ctx context.Context, botId string) (*showBotResponse, []byte, error) {
 	client := http.DefaultClient
 
-	req, err := buildShowBotRequest(botId)
+	req, err := buildShowBotRequest(ctx, botId)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
 
-	if err := processShowBotResponse(resp); err != nil {
+	return processShowBotResponse(resp)
+}
+
+func execShowBot(ctx context.Context, botId string) error {
+	_, body, err := cachedFetchBot(ctx, botId)
+	if err != nil {
 		return err
 	}
 
+	fmt.Println(string(body))
+
 	return nil
 }
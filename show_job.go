@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+)
+
+type showJobResponse struct {
+	Code        int             `json:"code"`
+	JobId       string          `json:"job_id"`
+	BotId       string          `json:"bot_id"`
+	BotName     string          `json:"bot_name"`
+	Status      int             `json:"status"`
+	StartTime   string          `json:"start_time"`
+	ElapsedTime int             `json:"elapsed_time"`
+	Input       json.RawMessage `json:"input"`
+	Output      json.RawMessage `json:"output"`
+	Message     string          `json:"message"`
+}
+
+func (r *showJobResponse) StatusString() string {
+	return jobStatusString(r.Status)
+}
+
+func buildShowJobURL(jobId string) (string, error) {
+	u, err := url.Parse(UserConfig.ApiPath)
+	if err != nil {
+		return "", err
+	}
+
+	u.Path = path.Join(u.Path, "jobs", jobId)
+
+	q := u.Query()
+	q.Set("properties", "input,output")
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+func buildShowJobRequest(ctx context.Context, jobId string) (*http.Request, error) {
+	url, err := buildShowJobURL(jobId)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("content-type", "application/json")
+	req.Header.Add("content-language", UserConfig.ContentLanguage)
+	req.Header.Add("access-token", UserConfig.AccessToken)
+	req.Header.Add("secret-key", UserConfig.SecretKey)
+
+	return req, nil
+}
+
+func processShowJobResponse(resp *http.Response) (*showJobResponse, error) {
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret showJobResponse
+	err = json.Unmarshal(body, &ret)
+	if err != nil {
+		return nil, err
+	}
+
+	switch ret.Code {
+	case 200:
+		// nothing todo
+	case 401:
+		// unauthorized
+		return nil, UnauthorizedError
+	case 403:
+		// forbidden
+		return nil, ForbiddenError
+	case 404:
+		// not found
+		return nil, JobNotFoundError
+	default:
+		// error
+		return nil, &responseCodeError{Code: ret.Code}
+	}
+
+	return &ret, nil
+}
+
+func execShowJob(ctx context.Context, jobId string) (*showJobResponse, error) {
+	client := http.DefaultClient
+
+	req, err := buildShowJobRequest(ctx, jobId)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return processShowJobResponse(resp)
+}
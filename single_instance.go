@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	LockDirName = "locks"
+
+	OnRunningSkip  = "skip"
+	OnRunningWait  = "wait"
+	OnRunningAbort = "abort"
+)
+
+var (
+	BotStartSkippedError = errors.New("bot start is skipped")
+)
+
+func runningJobs(ctx context.Context, botId string) ([]listingJobsResponseJob, error) {
+	ret, _, err := fetchJobs(ctx, botId)
+	if err != nil {
+		return nil, err
+	}
+	var jobs []listingJobsResponseJob
+	for _, j := range ret.Jobs {
+		if j.Status == JobStatusRunning {
+			jobs = append(jobs, j)
+		}
+	}
+	return jobs, nil
+}
+
+// lockBot takes the host-wide lock of the bot so that concurrent CLI
+// invocations do not submit between the check and the submission.
+func lockBot(botId string) (*fileLock, error) {
+	name := currentProfileName() + "_" + strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' {
+			return '_'
+		}
+		return r
+	}, botId)
+	lock, err := lockFile(filepath.Join(getConfigDir(), LockDirName, name), DefaultLockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	lock.releaseOnInterrupt()
+	return lock, nil
+}
+
+// acquireIdleBot returns with the bot lock held when no job of the bot
+// is running. Running jobs are handled as onRunning says. The caller
+// must unlock after the submission. The confirmation of the protected
+// profile is asked before the lock is taken.
+func acquireIdleBot(ctx context.Context, botId string, onRunning string, interval time.Duration) (*fileLock, error) {
+	switch onRunning {
+	case OnRunningSkip, OnRunningWait, OnRunningAbort:
+	default:
+		return nil, fmt.Errorf("on-running must be '%s', '%s' or '%s'.", OnRunningSkip, OnRunningWait, OnRunningAbort)
+	}
+
+	// confirmed once per bot, so runBot and the aborts do not ask again
+	// while the lock is held
+	if err := guardMutation(ctx, AuditOperationRun, botId, ""); err != nil {
+		return nil, err
+	}
+
+	aborted := false
+	for {
+		lock, err := lockBot(botId)
+		if err != nil {
+			return nil, err
+		}
+
+		jobs, err := runningJobs(ctx, botId)
+		if err != nil {
+			lock.Unlock()
+			return nil, err
+		}
+		if len(jobs) == 0 {
+			return lock, nil
+		}
+		// the lock is released while skipping, aborting and waiting for
+		// others to be able to check
+		lock.Unlock()
+
+		ids := make([]string, len(jobs))
+		for i, j := range jobs {
+			ids[i] = j.JobId
+		}
+
+		switch onRunning {
+		case OnRunningSkip:
+			fmt.Fprintf(os.Stderr, "bot id '%s' is already running. job: %s\n", botId, strings.Join(ids, ", "))
+			return nil, BotStartSkippedError
+		case OnRunningAbort:
+			if !aborted {
+				for _, id := range ids {
+					if err := execAbortJob(ctx, id, false); err != nil && err != JobAlreadyDoneError {
+						return nil, fmt.Errorf("job '%s' abort failed. %v", id, err)
+					}
+					fmt.Fprintf(os.Stderr, "job '%s' is aborted.\n", id)
+				}
+				aborted = true
+			}
+		}
+
+		fmt.Fprintf(os.Stderr, "waiting for job %s of bot id '%s' to finish...\n", strings.Join(ids, ", "), botId)
+		if err := sleepContext(ctx, interval); err != nil {
+			return nil, err
+		}
+	}
+}
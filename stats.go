@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	TrendNone  = "none"
+	TrendDay   = "day"
+	TrendWeek  = "week"
+	TrendMonth = "month"
+)
+
+var (
+	jobStartTimeLayouts = []string{
+		"2006-01-02 15:04:05",
+		"2006/01/02 15:04:05",
+		time.RFC3339,
+	}
+)
+
+type jobStats struct {
+	Period      string  `json:"period"`
+	Runs        int     `json:"runs"`
+	Exit        int     `json:"exit"`
+	Error       int     `json:"error"`
+	Running     int     `json:"running"`
+	SuccessRate float64 `json:"success_rate"`
+	ErrorRate   float64 `json:"error_rate"`
+	ElapsedMin  int     `json:"elapsed_min"`
+	ElapsedAvg  float64 `json:"elapsed_avg"`
+	ElapsedP50  int     `json:"elapsed_p50"`
+	ElapsedP95  int     `json:"elapsed_p95"`
+	ElapsedMax  int     `json:"elapsed_max"`
+}
+
+type botStats struct {
+	BotId   string      `json:"bot_id"`
+	BotName string      `json:"bot_name"`
+	Summary *jobStats   `json:"summary"`
+	Trend   []*jobStats `json:"trend,omitempty"`
+
+	// jobs skipped for the start time in an unknown format
+	Unparseable int `json:"unparseable"`
+	// jobs in the window skipped for an unknown status
+	UnknownStatus int `json:"unknown_status"`
+	// Cloud Bot listed the maximum number of jobs, and older jobs in
+	// the window may be missing
+	Incomplete bool `json:"incomplete"`
+}
+
+func statsCommand(args []string) {
+	var formatType string
+	var botId string
+	var since string
+	var until string
+	var trend string
+
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	fs.StringVar(&formatType, "f", "text", "output format type")
+	fs.StringVar(&botId, "b", "", "bot id")
+	fs.StringVar(&since, "since", "30d", "window start")
+	fs.StringVar(&until, "until", "", "window end")
+	fs.StringVar(&trend, "trend", TrendDay, "trend period")
+
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, `Usage: cbot-cli stats [OPTION]...
+  Options:
+    -b BOT               : statistics of the bot only.[default all bots]
+    -since TIME          : window start.(ex: 2006-01-02, '2006-01-02 15:04', 24h, 7d)[default 30d]
+    -until TIME          : window end.[default now]
+    -trend PERIOD        : trend period.(day, week, month or none)[default 'day']
+    -f text | json | csv : output format type.[default 'text']
+`)
+	}
+	fs.Parse(args)
+
+	switch trend {
+	case TrendNone, TrendDay, TrendWeek, TrendMonth:
+	default:
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	from, err := parseTimeArg(since)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		os.Exit(1)
+	}
+	to, err := parseTimeArg(until)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		os.Exit(1)
+	}
+
+	if botId != "" {
+		botId = resolveBotIdPortal(botId)
+	}
+
+	statsPortal(botId, from, to, trend, formatType)
+}
+
+func statsPortal(botId string, from time.Time, to time.Time, trend string, format string) {
+	err := execStats(commandContext(), botId, from, to, trend, format)
+	if err == UnauthorizedError {
+		fmt.Fprintf(os.Stderr, "unauthorized error returned. Check your access token and key.")
+		os.Exit(1)
+	} else if err == ForbiddenError {
+		fmt.Fprintf(os.Stderr, "forbidden error returned. Do you have a reference authorize?")
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		os.Exit(1)
+	}
+}
+
+func parseJobStartTime(s string) (time.Time, error) {
+	for _, layout := range jobStartTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown start time format '%s'.", s)
+}
+
+func trendPeriod(t time.Time, trend string) string {
+	switch trend {
+	case TrendWeek:
+		// weeks start on monday
+		offset := (int(t.Weekday()) + 6) % 7
+		return t.AddDate(0, 0, -offset).Format("2006-01-02")
+	case TrendMonth:
+		return t.Format("2006-01")
+	default:
+		return t.Format("2006-01-02")
+	}
+}
+
+// percentile returns the nearest-rank percentile of the sorted values.
+func percentile(sorted []int, p float64) int {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
+
+func computeJobStats(period string, jobs []listingJobsResponseJob) *jobStats {
+	s := &jobStats{Period: period, Runs: len(jobs)}
+
+	var elapsed []int
+	total := 0
+	for _, j := range jobs {
+		switch j.Status {
+		case JobStatusExit:
+			s.Exit++
+		case JobStatusError:
+			s.Error++
+		case JobStatusRunning:
+			s.Running++
+			continue
+		}
+		elapsed = append(elapsed, j.ElapsedTime)
+		total += j.ElapsedTime
+	}
+
+	if finished := s.Exit + s.Error; finished > 0 {
+		s.SuccessRate = float64(s.Exit) / float64(finished)
+		s.ErrorRate = float64(s.Error) / float64(finished)
+	}
+	if len(elapsed) > 0 {
+		sort.Ints(elapsed)
+		s.ElapsedMin = elapsed[0]
+		s.ElapsedMax = elapsed[len(elapsed)-1]
+		s.ElapsedAvg = float64(total) / float64(len(elapsed))
+		s.ElapsedP50 = percentile(elapsed, 50)
+		s.ElapsedP95 = percentile(elapsed, 95)
+	}
+	return s
+}
+
+func computeBotStats(botId string, botName string, jobs []listingJobsResponseJob, from time.Time, to time.Time, trend string) *botStats {
+	var inWindow []listingJobsResponseJob
+	periods := make(map[string][]listingJobsResponseJob)
+	unparseable, unknownStatus := 0, 0
+	var oldest time.Time
+
+	for _, j := range jobs {
+		t, err := parseJobStartTime(j.StartTime)
+		if err != nil {
+			unparseable++
+			continue
+		}
+		if oldest.IsZero() || t.Before(oldest) {
+			oldest = t
+		}
+		if !from.IsZero() && t.Before(from) {
+			continue
+		}
+		if !to.IsZero() && !t.Before(to) {
+			continue
+		}
+		if botName == "" {
+			botName = j.BotName
+		}
+		switch j.Status {
+		case JobStatusExit, JobStatusError, JobStatusRunning:
+		default:
+			unknownStatus++
+			continue
+		}
+		inWindow = append(inWindow, j)
+		if trend != TrendNone {
+			p := trendPeriod(t, trend)
+			periods[p] = append(periods[p], j)
+		}
+	}
+
+	max, _ := strconv.Atoi(MAX_LISTING_JOBS)
+	ret := &botStats{
+		BotId:         botId,
+		BotName:       botName,
+		Summary:       computeJobStats("all", inWindow),
+		Unparseable:   unparseable,
+		UnknownStatus: unknownStatus,
+		Incomplete:    len(jobs) >= max && (from.IsZero() || !oldest.Before(from)),
+	}
+
+	keys := make([]string, 0, len(periods))
+	for p := range periods {
+		keys = append(keys, p)
+	}
+	sort.Strings(keys)
+	for _, p := range keys {
+		ret.Trend = append(ret.Trend, computeJobStats(p, periods[p]))
+	}
+	return ret
+}
+
+func execStats(ctx context.Context, botId string, from time.Time, to time.Time, trend string, format string) error {
+	type target struct {
+		id   string
+		name string
+	}
+	var targets []target
+
+	if botId != "" {
+		targets = append(targets, target{id: botId})
+	} else {
+		bots, _, err := cachedFetchBots(ctx)
+		if err != nil {
+			return err
+		}
+		for _, b := range bots.Bots {
+			targets = append(targets, target{id: b.Id, name: b.Name})
+		}
+	}
+
+	var stats []*botStats
+	for _, t := range targets {
+		jobs, _, err := fetchJobs(ctx, t.id)
+		if err != nil {
+			return err
+		}
+		s := computeBotStats(t.id, t.name, jobs.Jobs, from, to, trend)
+		if s.Unparseable > 0 {
+			fmt.Fprintf(os.Stderr, "bot id '%s': %d jobs are skipped for the unknown start time format.\n", t.id, s.Unparseable)
+		}
+		if s.UnknownStatus > 0 {
+			fmt.Fprintf(os.Stderr, "bot id '%s': %d jobs are skipped for the unknown status.\n", t.id, s.UnknownStatus)
+		}
+		if s.Incomplete {
+			fmt.Fprintf(os.Stderr, "bot id '%s': Cloud Bot lists the latest %s jobs only. The older jobs in the window are not counted.\n", t.id, MAX_LISTING_JOBS)
+		}
+		stats = append(stats, s)
+	}
+
+	switch format {
+	case "json":
+		return printStatsJSON(stats)
+	case "csv":
+		return printStatsCSV(stats)
+	default:
+		printStatsText(stats)
+		return nil
+	}
+}
+
+func printStatsJSON(stats []*botStats) error {
+	if stats == nil {
+		stats = []*botStats{}
+	}
+	b, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+func (s *jobStats) record() []string {
+	return []string{
+		s.Period,
+		strconv.Itoa(s.Runs),
+		strconv.Itoa(s.Exit),
+		strconv.Itoa(s.Error),
+		strconv.Itoa(s.Running),
+		strconv.FormatFloat(s.SuccessRate, 'f', 3, 64),
+		strconv.FormatFloat(s.ErrorRate, 'f', 3, 64),
+		strconv.Itoa(s.ElapsedMin),
+		strconv.FormatFloat(s.ElapsedAvg, 'f', 1, 64),
+		strconv.Itoa(s.ElapsedP50),
+		strconv.Itoa(s.ElapsedP95),
+		strconv.Itoa(s.ElapsedMax),
+	}
+}
+
+var jobStatsHeader = []string{"period", "runs", "exit", "error", "running", "success_rate", "error_rate", "elapsed_min", "elapsed_avg", "elapsed_p50", "elapsed_p95", "elapsed_max"}
+
+func printStatsCSV(stats []*botStats) error {
+	w := csv.NewWriter(os.Stdout)
+	w.Write(append([]string{"bot_id", "bot_name"}, jobStatsHeader...))
+	for _, b := range stats {
+		w.Write(append([]string{b.BotId, b.BotName}, b.Summary.record()...))
+		for _, t := range b.Trend {
+			w.Write(append([]string{b.BotId, b.BotName}, t.record()...))
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func printStatsText(stats []*botStats) {
+	fmt.Println("bot_id\tbot_name\truns\texit\terror\trunning\tsuccess\tmin\tavg\tp50\tp95\tmax")
+	for _, b := range stats {
+		s := b.Summary
+		fmt.Printf("%s\t%s\t%d\t%d\t%d\t%d\t%.1f%%\t%d\t%.1f\t%d\t%d\t%d\n", b.BotId, b.BotName, s.Runs, s.Exit, s.Error, s.Running, s.SuccessRate*100, s.ElapsedMin, s.ElapsedAvg, s.ElapsedP50, s.ElapsedP95, s.ElapsedMax)
+	}
+
+	for _, b := range stats {
+		if len(b.Trend) == 0 {
+			continue
+		}
+		fmt.Printf("\n%s (%s)\n", b.BotId, b.BotName)
+		fmt.Println("period\truns\texit\terror\tsuccess\tavg\tp95")
+		for _, t := range b.Trend {
+			fmt.Printf("%s\t%d\t%d\t%d\t%.1f%%\t%.1f\t%d\n", t.Period, t.Runs, t.Exit, t.Error, t.SuccessRate*100, t.ElapsedAvg, t.ElapsedP95)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	DefaultWaitInterval = 5 * time.Second
+	MinWaitInterval     = time.Second
+)
+
+// validateInterval rejects the polling intervals which would flood
+// Cloud Bot with requests.
+func validateInterval(interval time.Duration) error {
+	if interval < MinWaitInterval {
+		return fmt.Errorf("invalidate interval '%v'. It must be %v or more.", interval, MinWaitInterval)
+	}
+	return nil
+}
+
+// waitJob polls the job until it is no longer running and returns the
+// last fetched state. The final status is recorded and notified.
+// Polling stops when ctx is done.
+func waitJob(ctx context.Context, jobId string, interval time.Duration) (*showJobResponse, error) {
+	for {
+		job, err := execShowJob(ctx, jobId)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+		if job.Status != JobStatusRunning {
+			recordJobStatus(job)
+			notifyJobFinished(job)
+			return job, nil
+		}
+
+		if err := sleepContext(ctx, interval); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+func watchCommand(args []string) {
+	var waitInterval int
+
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	fs.IntVar(&waitInterval, "interval", int(DefaultWaitInterval/time.Second), "polling interval seconds")
+
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, `Usage: cbot-cli watch [OPTION]... [JOB_ID]
+  Wait for the job to finish and print its result.
+  On a terminal, the job is picked from a list when JOB_ID is omitted.
+  Options:
+    -interval SECONDS : polling interval.[default 5]
+`)
+	}
+
+	fs.Parse(args)
+	interval := time.Duration(waitInterval) * time.Second
+	if err := validateInterval(interval); err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		os.Exit(1)
+	}
+	jobId, ok := jobArgPortal(fs.Args())
+	if !ok {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	watchJobPortal(jobId, interval)
+}
+
+func watchJobPortal(jobId string, interval time.Duration) {
+	err := execWatchJob(commandContext(), jobId, interval)
+	if err == UnauthorizedError {
+		fmt.Fprintf(os.Stderr, "unauthorized error returned. Check your access token and key.")
+		os.Exit(1)
+	} else if err == ForbiddenError {
+		fmt.Fprintf(os.Stderr, "forbidden error returned. Do you have a reference authorize?")
+		os.Exit(1)
+	} else if err == JobNotFoundError {
+		fmt.Fprintf(os.Stderr, "job id '%s' is not found.", jobId)
+		os.Exit(1)
+	} else if err == JobFailedError {
+		// the job result is already printed
+		os.Exit(1)
+	} else if err == InterruptedError {
+		os.Exit(InterruptExitCode)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		os.Exit(1)
+	}
+}
+
+func execWatchJob(ctx context.Context, jobId string, interval time.Duration) error {
+	f := newJobFollower(ctx)
+	defer f.Stop()
+
+	job, err := f.waitJob(jobId, interval)
+	if err != nil {
+		return err
+	}
+
+	return printJobResult(job)
+}
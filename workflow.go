@@ -0,0 +1,667 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	StepStatusPending   = "pending"
+	StepStatusRunning   = "running"
+	StepStatusExit      = "exit"
+	StepStatusError     = "error"
+	StepStatusSkipped   = "skipped"
+	StepStatusCancelled = "cancelled"
+	StepStatusTimedOut  = "timed_out"
+
+	WorkflowStatusSucceeded = "succeeded"
+	WorkflowStatusFailed    = "failed"
+	WorkflowStatusTimedOut  = "timed_out"
+)
+
+var (
+	WorkflowFailedError = errors.New("workflow failed")
+)
+
+type workflowDefinition struct {
+	Name  string          `json:"name"`
+	Steps []*workflowStep `json:"steps"`
+}
+
+type workflowStep struct {
+	Id               string            `json:"id"`
+	BotId            string            `json:"bot_id"`
+	Input            map[string]string `json:"input"`
+	Needs            []string          `json:"needs"`
+	When             string            `json:"when"`
+	Retries          int               `json:"retries"`
+	RetryInterval    int               `json:"retry_interval"`
+	TimeoutTime      int               `json:"timeout_time"`
+	CallbackEndpoint string            `json:"callback_endpoint"`
+	CallbackTries    int               `json:"callback_tries"`
+	ContinueOnError  bool              `json:"continue_on_error"`
+}
+
+type workflowStepReport struct {
+	Id       string            `json:"id"`
+	BotId    string            `json:"bot_id"`
+	Status   string            `json:"status"`
+	JobId    string            `json:"job_id,omitempty"`
+	Attempts int               `json:"attempts"`
+	Started  *time.Time        `json:"started,omitempty"`
+	Finished *time.Time        `json:"finished,omitempty"`
+	Input    map[string]string `json:"input,omitempty"`
+	Output   json.RawMessage   `json:"output,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
+type workflowReport struct {
+	Name     string                `json:"name"`
+	Status   string                `json:"status"`
+	Started  time.Time             `json:"started"`
+	Finished time.Time             `json:"finished"`
+	Params   map[string]string     `json:"params"`
+	Steps    []*workflowStepReport `json:"steps"`
+}
+
+type workflowRunner struct {
+	def      *workflowDefinition
+	params   map[string]string
+	interval time.Duration
+	follower *jobFollower
+
+	mu      sync.Mutex
+	reports map[string]*workflowStepReport
+	failed  bool
+}
+
+func workflowCommand(args []string) {
+	var params string
+	var reportPath string
+	var waitInterval int
+
+	fs := flag.NewFlagSet("workflow", flag.ExitOnError)
+	fs.StringVar(&params, "p", "", "workflow parameters")
+	fs.StringVar(&reportPath, "report", "", "report file")
+	fs.IntVar(&waitInterval, "interval", int(DefaultWaitInterval/time.Second), "polling interval seconds")
+
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, `Usage: cbot-cli workflow [OPTION]... FILE
+  FILE is a JSON or YAML(.yaml, .yml) workflow definition.
+  Options:
+    -p                : workflow parameters.(ex: key:value,key2:value2...)[default '']
+    -report FILE      : write the run report to FILE instead of stdout.
+    -interval SECONDS : polling interval while waiting jobs.[default 5]
+`)
+	}
+
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	interval := time.Duration(waitInterval) * time.Second
+	if err := validateInterval(interval); err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		os.Exit(1)
+	}
+
+	workflowPortal(fs.Arg(0), params, reportPath, interval)
+}
+
+func workflowPortal(path string, params string, reportPath string, interval time.Duration) {
+	err := execWorkflow(commandContext(), path, params, reportPath, interval)
+	if err == UnauthorizedError {
+		fmt.Fprintf(os.Stderr, "unauthorized error returned. Check your access token and key.")
+		os.Exit(1)
+	} else if err == ForbiddenError {
+		fmt.Fprintf(os.Stderr, "forbidden error returned. Do you have a bot execute authorize?")
+		os.Exit(1)
+	} else if err == WorkflowFailedError {
+		// the report is already written
+		os.Exit(1)
+	} else if err == InterruptedError {
+		// the report is already written
+		os.Exit(InterruptExitCode)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		os.Exit(1)
+	}
+}
+
+func parseKeyValues(s string) (map[string]string, error) {
+	p := execParameter{execInputParam: s}
+	if err := setupParameter(&p); err != nil {
+		return nil, err
+	}
+	return p.Input, nil
+}
+
+func loadWorkflow(path string) (*workflowDefinition, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("workflow file load failed. %v: %v", path, err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if b, err = yamlToJSON(b); err != nil {
+			return nil, fmt.Errorf("workflow file load failed. %v: %v", path, err)
+		}
+	}
+
+	var def workflowDefinition
+	if err := json.Unmarshal(b, &def); err != nil {
+		return nil, fmt.Errorf("workflow file load failed. %v: %v", path, err)
+	}
+
+	if err := validateWorkflow(&def); err != nil {
+		return nil, err
+	}
+	return &def, nil
+}
+
+func validateWorkflow(def *workflowDefinition) error {
+	steps := make(map[string]*workflowStep)
+	for i, s := range def.Steps {
+		if s.Id == "" {
+			return fmt.Errorf("step #%d has no id.", i+1)
+		}
+		if _, ok := steps[s.Id]; ok {
+			return fmt.Errorf("step id '%s' is duplicated.", s.Id)
+		}
+		if s.BotId == "" {
+			return fmt.Errorf("step '%s' has no bot_id.", s.Id)
+		}
+		if s.Retries < 0 {
+			return fmt.Errorf("step '%s' retries must be 0 or more.", s.Id)
+		}
+		steps[s.Id] = s
+	}
+
+	for _, s := range def.Steps {
+		for _, n := range s.Needs {
+			if _, ok := steps[n]; !ok {
+				return fmt.Errorf("step '%s' needs unknown step '%s'.", s.Id, n)
+			}
+		}
+	}
+
+	// detect cycles by depth first search
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	marks := make(map[string]int)
+	var visit func(id string) error
+	visit = func(id string) error {
+		switch marks[id] {
+		case visiting:
+			return fmt.Errorf("step '%s' has a circular dependency.", id)
+		case visited:
+			return nil
+		}
+		marks[id] = visiting
+		for _, n := range steps[id].Needs {
+			if err := visit(n); err != nil {
+				return err
+			}
+		}
+		marks[id] = visited
+		return nil
+	}
+	for _, s := range def.Steps {
+		if err := visit(s.Id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func execWorkflow(ctx context.Context, path string, params string, reportPath string, interval time.Duration) error {
+	if err := startBulkOperation(); err != nil {
+		return err
+	}
+
+	def, err := loadWorkflow(path)
+	if err != nil {
+		return err
+	}
+
+	p, err := parseKeyValues(params)
+	if err != nil {
+		return errors.New("parameter format is invalidate. Ex: key:value")
+	}
+
+	for _, s := range def.Steps {
+		if s.BotId, err = resolveBotId(ctx, s.BotId); err != nil {
+			return err
+		}
+	}
+
+	r := newWorkflowRunner(def, p, interval)
+	report, fatal := r.Run(ctx)
+
+	// the report is written even on fatal errors with the final step
+	// states
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if reportPath == "" {
+		fmt.Println(string(b))
+	} else if err := ioutil.WriteFile(reportPath, b, 0644); err != nil {
+		return err
+	}
+
+	if fatal != nil {
+		return fatal
+	}
+	if report.Status != WorkflowStatusSucceeded {
+		return WorkflowFailedError
+	}
+	return nil
+}
+
+func newWorkflowRunner(def *workflowDefinition, params map[string]string, interval time.Duration) *workflowRunner {
+	reports := make(map[string]*workflowStepReport)
+	for _, s := range def.Steps {
+		reports[s.Id] = &workflowStepReport{Id: s.Id, BotId: s.BotId, Status: StepStatusPending}
+	}
+	return &workflowRunner{
+		def:      def,
+		params:   params,
+		interval: interval,
+		reports:  reports,
+	}
+}
+
+// templateData returns the values referable from step templates.
+//
+//	.params.KEY              : workflow parameters
+//	.steps.ID.status         : status of the step
+//	.steps.ID.job_id         : job id of the step
+//	.steps.ID.output.KEY     : output of the step job
+func (r *workflowRunner) templateData() map[string]interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	steps := make(map[string]interface{})
+	for id, rep := range r.reports {
+		var output interface{}
+		if len(rep.Output) > 0 {
+			json.Unmarshal(rep.Output, &output)
+		}
+		steps[id] = map[string]interface{}{
+			"status": rep.Status,
+			"job_id": rep.JobId,
+			"output": output,
+			"error":  rep.Error,
+		}
+	}
+	return map[string]interface{}{
+		"params": r.params,
+		"steps":  steps,
+	}
+}
+
+func renderTemplate(name string, text string, data interface{}) (string, error) {
+	t, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (r *workflowRunner) renderInput(s *workflowStep) (map[string]string, error) {
+	data := r.templateData()
+	input := make(map[string]string)
+	for k, v := range s.Input {
+		rendered, err := renderTemplate(s.Id+"."+k, v, data)
+		if err != nil {
+			return nil, fmt.Errorf("input '%s' template error. %v", k, err)
+		}
+		input[k] = rendered
+	}
+	return input, nil
+}
+
+func (r *workflowRunner) evaluateWhen(s *workflowStep) (bool, error) {
+	if s.When == "" {
+		return true, nil
+	}
+	v, err := renderTemplate(s.Id+".when", s.When, r.templateData())
+	if err != nil {
+		return false, fmt.Errorf("when template error. %v", err)
+	}
+	v = strings.TrimSpace(v)
+	return v != "" && v != "false" && v != "0", nil
+}
+
+func (r *workflowRunner) setStatus(id string, status string, errMsg string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rep := r.reports[id]
+	rep.Status = status
+	if errMsg != "" {
+		rep.Error = errMsg
+	}
+	if status != StepStatusRunning && rep.Started != nil && rep.Finished == nil {
+		now := time.Now()
+		rep.Finished = &now
+	}
+}
+
+func (r *workflowRunner) status(id string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.reports[id].Status
+}
+
+func isFinishedStepStatus(status string) bool {
+	return status != StepStatusPending && status != StepStatusRunning
+}
+
+// dependencyState returns whether the needs of the step are finished,
+// and the status the step should take if they did not succeed.
+func (r *workflowRunner) dependencyState(s *workflowStep, steps map[string]*workflowStep) (bool, string) {
+	blocked := ""
+	for _, n := range s.Needs {
+		status := r.status(n)
+		if !isFinishedStepStatus(status) {
+			return false, ""
+		}
+		switch status {
+		case StepStatusExit:
+			// satisfied
+		case StepStatusError:
+			if !steps[n].ContinueOnError {
+				blocked = StepStatusCancelled
+			}
+		default:
+			if blocked == "" {
+				blocked = status
+			}
+		}
+	}
+	return true, blocked
+}
+
+// runStep executes the bot of the step and waits the job with retries.
+func (r *workflowRunner) runStep(ctx context.Context, s *workflowStep) error {
+	input, err := r.renderInput(s)
+	if err != nil {
+		r.setStatus(s.Id, StepStatusError, err.Error())
+		return nil
+	}
+
+	r.mu.Lock()
+	rep := r.reports[s.Id]
+	now := time.Now()
+	rep.Started = &now
+	rep.Input = input
+	r.mu.Unlock()
+
+	param := execParameter{
+		TimeoutTime:      s.TimeoutTime,
+		CallbackEndpoint: s.CallbackEndpoint,
+		CallbackTries:    s.CallbackTries,
+		Input:            input,
+	}
+
+	for attempt := 1; ; attempt++ {
+		r.mu.Lock()
+		rep.Attempts = attempt
+		rep.Output = nil
+		r.mu.Unlock()
+
+		fmt.Fprintf(os.Stderr, "%s: starting bot '%s' (attempt %d)\n", s.Id, s.BotId, attempt)
+		errMsg := ""
+		ret, _, err := runBot(ctx, s.BotId, param)
+		if err == UnauthorizedError || err == ForbiddenError {
+			return err
+		} else if err != nil {
+			errMsg = err.Error()
+		} else {
+			r.mu.Lock()
+			rep.JobId = ret.JobId
+			failed := r.failed
+			if failed {
+				// abortRunning may have missed the job submitted at the
+				// same time
+				rep.Status = StepStatusCancelled
+				now := time.Now()
+				rep.Finished = &now
+			}
+			r.mu.Unlock()
+
+			if failed {
+				fmt.Fprintf(os.Stderr, "%s: aborting job '%s'.\n", s.Id, ret.JobId)
+				if err := execAbortJob(context.Background(), ret.JobId, false); err != nil && err != JobAlreadyDoneError {
+					fmt.Fprintf(os.Stderr, "%s: abort job '%s' failed. %v\n", s.Id, ret.JobId, err)
+				}
+				return nil
+			}
+
+			job, err := r.follower.waitJob(ret.JobId, r.interval)
+			if err != nil {
+				errMsg = err.Error()
+			} else {
+				r.mu.Lock()
+				rep.Output = job.Output
+				r.mu.Unlock()
+				if job.Status == JobStatusExit {
+					fmt.Fprintf(os.Stderr, "%s: job '%s' finished.\n", s.Id, ret.JobId)
+					r.setStatus(s.Id, StepStatusExit, "")
+					return nil
+				}
+				errMsg = strings.TrimSpace(fmt.Sprintf("job '%s' finished with error. %s", ret.JobId, job.Message))
+			}
+		}
+
+		fmt.Fprintf(os.Stderr, "%s: %s\n", s.Id, errMsg)
+		if r.follower.isInterrupted() {
+			r.setStatus(s.Id, StepStatusCancelled, "interrupted.")
+			return r.follower.interruptedError()
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			// --timeout stops waiting. The job is not a failure and
+			// keeps running.
+			r.setStatus(s.Id, StepStatusTimedOut, errMsg)
+			return nil
+		}
+		if r.isFailed() {
+			// aborted by the failure of another step
+			r.setStatus(s.Id, StepStatusCancelled, errMsg)
+			return nil
+		}
+		if attempt > s.Retries {
+			r.setStatus(s.Id, StepStatusError, errMsg)
+			return nil
+		}
+		if err := sleepContext(ctx, time.Duration(s.RetryInterval)*time.Second); err != nil {
+			if r.follower.isInterrupted() {
+				r.setStatus(s.Id, StepStatusCancelled, "interrupted.")
+				return r.follower.interruptedError()
+			}
+			r.setStatus(s.Id, StepStatusTimedOut, errMsg)
+			return nil
+		}
+	}
+}
+
+func (r *workflowRunner) isFailed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.failed
+}
+
+// markFailed marks the workflow failed and aborts the running jobs
+// at the first failure.
+func (r *workflowRunner) markFailed() {
+	r.mu.Lock()
+	alreadyFailed := r.failed
+	r.failed = true
+	r.mu.Unlock()
+
+	if !alreadyFailed {
+		r.abortRunning()
+	}
+}
+
+// abortRunning aborts the jobs of the steps still running.
+func (r *workflowRunner) abortRunning() {
+	r.mu.Lock()
+	var jobs []*workflowStepReport
+	for _, rep := range r.reports {
+		if rep.Status == StepStatusRunning && rep.JobId != "" {
+			jobs = append(jobs, rep)
+		}
+	}
+	r.mu.Unlock()
+
+	// aborted even after the context of the workflow is done
+	ctx := context.Background()
+	for _, rep := range jobs {
+		fmt.Fprintf(os.Stderr, "%s: aborting job '%s'.\n", rep.Id, rep.JobId)
+		if err := execAbortJob(ctx, rep.JobId, false); err != nil && err != JobAlreadyDoneError {
+			fmt.Fprintf(os.Stderr, "%s: abort job '%s' failed. %v\n", rep.Id, rep.JobId, err)
+		}
+	}
+}
+
+// Run executes the steps in dependency order. Steps whose needs are
+// satisfied run in parallel. When a step fails, the running jobs are
+// aborted and the remaining steps are cancelled. When --timeout passes,
+// the workflow stops waiting without aborting the jobs. Ctrl-C offers
+// to abort the running jobs.
+func (r *workflowRunner) Run(ctx context.Context) (*workflowReport, error) {
+	r.follower = newJobFollower(ctx)
+	defer r.follower.Stop()
+	// Ctrl-C stops the submissions and the retries too
+	ctx = r.follower.ctx
+
+	report := &workflowReport{
+		Name:    r.def.Name,
+		Started: time.Now(),
+		Params:  r.params,
+	}
+
+	steps := make(map[string]*workflowStep)
+	for _, s := range r.def.Steps {
+		steps[s.Id] = s
+	}
+
+	type stepResult struct {
+		step *workflowStep
+		err  error
+	}
+	results := make(chan stepResult)
+	running := 0
+	var fatal error
+
+	for {
+		progressed := true
+		for progressed {
+			progressed = false
+			for _, s := range r.def.Steps {
+				if r.status(s.Id) != StepStatusPending {
+					continue
+				}
+				if r.isFailed() {
+					r.setStatus(s.Id, StepStatusCancelled, "")
+					progressed = true
+					continue
+				}
+				if ctx.Err() == context.DeadlineExceeded {
+					r.setStatus(s.Id, StepStatusTimedOut, "timed out before the step started.")
+					progressed = true
+					continue
+				}
+
+				ready, blocked := r.dependencyState(s, steps)
+				if !ready {
+					continue
+				}
+				progressed = true
+				if blocked != "" {
+					r.setStatus(s.Id, blocked, "")
+					continue
+				}
+
+				ok, err := r.evaluateWhen(s)
+				if err != nil {
+					r.setStatus(s.Id, StepStatusError, err.Error())
+					if !s.ContinueOnError {
+						r.markFailed()
+					}
+					continue
+				}
+				if !ok {
+					r.setStatus(s.Id, StepStatusSkipped, "")
+					continue
+				}
+
+				r.setStatus(s.Id, StepStatusRunning, "")
+				running++
+				go func(s *workflowStep) {
+					results <- stepResult{step: s, err: r.runStep(ctx, s)}
+				}(s)
+			}
+		}
+
+		if running == 0 {
+			break
+		}
+
+		res := <-results
+		running--
+		if res.err != nil && fatal == nil {
+			fatal = res.err
+		}
+		if res.err == InterruptedError {
+			// the running jobs are aborted or left as the user chose.
+			// no more steps start.
+			r.mu.Lock()
+			r.failed = true
+			r.mu.Unlock()
+		} else if (res.err != nil || r.status(res.step.Id) == StepStatusError) && !res.step.ContinueOnError {
+			r.markFailed()
+		}
+	}
+
+	report.Finished = time.Now()
+	report.Status = WorkflowStatusSucceeded
+	for _, s := range r.def.Steps {
+		rep := r.reports[s.Id]
+		report.Steps = append(report.Steps, rep)
+		if (rep.Status == StepStatusError && !s.ContinueOnError) || rep.Status == StepStatusCancelled {
+			report.Status = WorkflowStatusFailed
+		} else if rep.Status == StepStatusTimedOut && report.Status == WorkflowStatusSucceeded {
+			report.Status = WorkflowStatusTimedOut
+		}
+	}
+	if fatal != nil {
+		report.Status = WorkflowStatusFailed
+	}
+	return report, fatal
+}
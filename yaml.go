@@ -0,0 +1,571 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The YAML subset of the workflow files. It has block mappings and
+// sequences, flow [...] and {...}, quoted and plain scalars, | and >
+// block scalars and comments. Anchors, tags and multiple documents are
+// not supported.
+
+var (
+	yamlIntPattern   = regexp.MustCompile(`^[-+]?[0-9]+$`)
+	yamlFloatPattern = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+)
+
+type yamlParser struct {
+	lines []string
+	pos   int
+}
+
+// yamlToJSON converts the YAML document to JSON so that it is decoded
+// to the structs with the json tags.
+func yamlToJSON(b []byte) ([]byte, error) {
+	p := &yamlParser{lines: strings.Split(strings.Replace(string(b), "\r\n", "\n", -1), "\n")}
+	for i, line := range p.lines {
+		if strings.TrimSpace(line) != "" && strings.HasPrefix(strings.TrimLeft(line, " "), "\t") {
+			return nil, fmt.Errorf("yaml line %d: tabs can not be used for indentation", i+1)
+		}
+	}
+	p.skipDocumentMarkers()
+
+	v, err := p.parseBlock(0)
+	if err != nil {
+		return nil, err
+	}
+	if _, text, ok := p.peek(); ok && text != "..." {
+		return nil, p.errorf("unexpected '%s'", text)
+	}
+	return json.Marshal(v)
+}
+
+func (p *yamlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("yaml line %d: %s", p.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *yamlParser) skipDocumentMarkers() {
+	if _, text, ok := p.peek(); ok && (text == "---" || strings.HasPrefix(text, "--- ")) {
+		p.lines[p.pos] = strings.TrimSpace(strings.TrimPrefix(text, "---"))
+	}
+}
+
+// peek returns the indent and the text without the comment of the next
+// line which has contents.
+func (p *yamlParser) peek() (int, string, bool) {
+	for ; p.pos < len(p.lines); p.pos++ {
+		line := p.lines[p.pos]
+		text := strings.TrimSpace(stripYAMLComment(line))
+		if text == "" {
+			continue
+		}
+		return len(line) - len(strings.TrimLeft(line, " ")), text, true
+	}
+	return 0, "", false
+}
+
+// stripYAMLComment removes # and after, which is not quoted and is at
+// the start or after a space.
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote == '\'' && c == '\'' && i+1 < len(line) && line[i+1] == '\'':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || strings.ContainsRune(" \t[{,:", rune(line[i-1])) {
+				quote = c
+			}
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// parseBlock parses the node whose lines are indented by indent or more.
+func (p *yamlParser) parseBlock(indent int) (interface{}, error) {
+	ind, text, ok := p.peek()
+	if !ok || ind < indent {
+		return nil, nil
+	}
+	if isYAMLSequenceItem(text) {
+		return p.parseSequence(ind)
+	}
+	if _, _, ok := splitYAMLKey(text); ok {
+		return p.parseMapping(ind)
+	}
+	return p.parseValue(ind, text)
+}
+
+func isYAMLSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func (p *yamlParser) parseSequence(indent int) (interface{}, error) {
+	seq := []interface{}{}
+	for {
+		ind, text, ok := p.peek()
+		if !ok || ind < indent {
+			return seq, nil
+		}
+		if !isYAMLSequenceItem(text) {
+			if ind == indent {
+				// the next key of the mapping having the sequence
+				return seq, nil
+			}
+			return nil, p.errorf("bad indentation of '%s'", text)
+		}
+		if ind > indent {
+			return nil, p.errorf("bad indentation of '%s'", text)
+		}
+
+		rest := strings.TrimLeft(text[1:], " ")
+		if rest == "" {
+			p.pos++
+			v, err := p.parseBlock(indent + 1)
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, v)
+			continue
+		}
+
+		if rest[0] == '|' || rest[0] == '>' {
+			v, err := p.parseBlockScalar(indent, rest)
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, v)
+			continue
+		}
+
+		// the item is parsed as if it started at the column after "- "
+		itemIndent := indent + len(text) - len(rest)
+		p.lines[p.pos] = strings.Repeat(" ", itemIndent) + rest
+		v, err := p.parseBlock(itemIndent)
+		if err != nil {
+			return nil, err
+		}
+		seq = append(seq, v)
+	}
+}
+
+func (p *yamlParser) parseMapping(indent int) (interface{}, error) {
+	m := map[string]interface{}{}
+	for {
+		ind, text, ok := p.peek()
+		if !ok || ind < indent {
+			return m, nil
+		}
+		if ind > indent {
+			return nil, p.errorf("bad indentation of '%s'", text)
+		}
+		key, rest, ok := splitYAMLKey(text)
+		if !ok {
+			return nil, p.errorf("'%s' is not a mapping entry", text)
+		}
+		k, err := parseYAMLKey(key)
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		if _, dup := m[k]; dup {
+			return nil, p.errorf("key '%s' is duplicated", k)
+		}
+
+		if rest == "" {
+			p.pos++
+			var v interface{}
+			if ind, text, ok := p.peek(); ok && ind == indent && isYAMLSequenceItem(text) {
+				// a sequence may be at the same indent as the key
+				v, err = p.parseSequence(indent)
+			} else {
+				v, err = p.parseBlock(indent + 1)
+			}
+			if err != nil {
+				return nil, err
+			}
+			m[k] = v
+			continue
+		}
+
+		v, err := p.parseValue(indent, rest)
+		if err != nil {
+			return nil, err
+		}
+		m[k] = v
+	}
+}
+
+// parseValue parses the value starting on the current line. Block
+// scalars and flow collections may continue on the following lines.
+func (p *yamlParser) parseValue(indent int, text string) (interface{}, error) {
+	if text[0] == '|' || text[0] == '>' {
+		return p.parseBlockScalar(indent, text)
+	}
+
+	if text[0] == '[' || text[0] == '{' {
+		// join the lines until the brackets are closed
+		for p.pos++; !yamlFlowClosed(text); p.pos++ {
+			if _, next, ok := p.peek(); ok {
+				text += " " + next
+			} else {
+				return nil, p.errorf("'%c' is not closed", text[0])
+			}
+		}
+	} else {
+		p.pos++
+	}
+
+	f := &yamlFlow{s: text}
+	v, err := f.parseValue(false)
+	if err != nil {
+		return nil, p.errorf("%v", err)
+	}
+	f.skipSpaces()
+	if f.i < len(f.s) {
+		return nil, p.errorf("unexpected '%s'", f.s[f.i:])
+	}
+	return v, nil
+}
+
+func yamlFlowClosed(s string) bool {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		}
+	}
+	return depth <= 0
+}
+
+func (p *yamlParser) parseBlockScalar(indent int, header string) (interface{}, error) {
+	style, chomp := header[0], byte(0)
+	if len(header) > 1 {
+		chomp = header[1]
+	}
+	if len(header) > 2 || (chomp != 0 && chomp != '-' && chomp != '+') {
+		return nil, p.errorf("block scalar header '%s' is not supported", header)
+	}
+	p.pos++
+
+	var lines []string
+	contentIndent := -1
+	for ; p.pos < len(p.lines); p.pos++ {
+		line := p.lines[p.pos]
+		if strings.TrimSpace(line) == "" {
+			lines = append(lines, "")
+			continue
+		}
+		ind := len(line) - len(strings.TrimLeft(line, " "))
+		if ind <= indent {
+			break
+		}
+		if contentIndent < 0 {
+			contentIndent = ind
+		}
+		if ind < contentIndent {
+			return nil, p.errorf("bad indentation of the block scalar")
+		}
+		lines = append(lines, line[contentIndent:])
+	}
+
+	// trailing blank lines belong to the chomping
+	n := len(lines)
+	for n > 0 && lines[n-1] == "" {
+		n--
+	}
+	trailing := len(lines) - n
+	lines = lines[:n]
+
+	var s string
+	if style == '|' {
+		s = strings.Join(lines, "\n")
+	} else {
+		for i, l := range lines {
+			switch {
+			case i == 0:
+			case l == "":
+				s += "\n"
+			case lines[i-1] == "":
+			default:
+				s += " "
+			}
+			s += l
+		}
+	}
+
+	switch {
+	case len(lines) == 0:
+	case chomp == '-':
+	case chomp == '+':
+		s += strings.Repeat("\n", trailing+1)
+	default:
+		s += "\n"
+	}
+	return s, nil
+}
+
+// splitYAMLKey splits "key: value" of the mapping entry.
+func splitYAMLKey(text string) (string, string, bool) {
+	if text[0] == '[' || text[0] == '{' || isYAMLSequenceItem(text) {
+		return "", "", false
+	}
+
+	start := 0
+	if text[0] == '"' || text[0] == '\'' {
+		f := &yamlFlow{s: text}
+		if _, err := f.parseQuoted(); err != nil {
+			return "", "", false
+		}
+		start = f.i
+	}
+	for i := start; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+func parseYAMLKey(key string) (string, error) {
+	f := &yamlFlow{s: key}
+	v, err := f.parseValue(false)
+	if err != nil {
+		return "", err
+	}
+	if f.i < len(key) {
+		return "", fmt.Errorf("key '%s' is not supported", key)
+	}
+	switch k := v.(type) {
+	case string:
+		return k, nil
+	case nil:
+		return "", fmt.Errorf("key is empty")
+	}
+	// keys are strings in JSON
+	return key, nil
+}
+
+// yamlFlow parses the scalars and the flow collections of a line.
+type yamlFlow struct {
+	s string
+	i int
+}
+
+func (f *yamlFlow) skipSpaces() {
+	for f.i < len(f.s) && f.s[f.i] == ' ' {
+		f.i++
+	}
+}
+
+func (f *yamlFlow) parseValue(inFlow bool) (interface{}, error) {
+	f.skipSpaces()
+	if f.i >= len(f.s) {
+		return nil, nil
+	}
+	switch f.s[f.i] {
+	case '[':
+		return f.parseFlowSequence()
+	case '{':
+		return f.parseFlowMapping()
+	case '"', '\'':
+		return f.parseQuoted()
+	case '&', '*', '!':
+		return nil, fmt.Errorf("anchors, aliases and tags are not supported")
+	}
+	return f.parsePlain(inFlow)
+}
+
+func (f *yamlFlow) parseFlowSequence() (interface{}, error) {
+	seq := []interface{}{}
+	f.i++
+	for {
+		f.skipSpaces()
+		if f.i >= len(f.s) {
+			return nil, fmt.Errorf("'[' is not closed")
+		}
+		if f.s[f.i] == ']' {
+			f.i++
+			return seq, nil
+		}
+		v, err := f.parseValue(true)
+		if err != nil {
+			return nil, err
+		}
+		seq = append(seq, v)
+		if err := f.parseFlowSeparator(']'); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (f *yamlFlow) parseFlowMapping() (interface{}, error) {
+	m := map[string]interface{}{}
+	f.i++
+	for {
+		f.skipSpaces()
+		if f.i >= len(f.s) {
+			return nil, fmt.Errorf("'{' is not closed")
+		}
+		if f.s[f.i] == '}' {
+			f.i++
+			return m, nil
+		}
+		k, err := f.parseValue(true)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			key = fmt.Sprint(k)
+		}
+		f.skipSpaces()
+		if f.i >= len(f.s) || f.s[f.i] != ':' {
+			return nil, fmt.Errorf("':' is expected after key '%s'", key)
+		}
+		f.i++
+		v, err := f.parseValue(true)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+		if err := f.parseFlowSeparator('}'); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (f *yamlFlow) parseFlowSeparator(end byte) error {
+	f.skipSpaces()
+	if f.i < len(f.s) && f.s[f.i] == ',' {
+		f.i++
+		return nil
+	}
+	if f.i < len(f.s) && f.s[f.i] == end {
+		return nil
+	}
+	return fmt.Errorf("',' or '%c' is expected", end)
+}
+
+func (f *yamlFlow) parseQuoted() (interface{}, error) {
+	quote := f.s[f.i]
+	f.i++
+	var b strings.Builder
+	for f.i < len(f.s) {
+		c := f.s[f.i]
+		switch {
+		case c == quote && quote == '\'' && f.i+1 < len(f.s) && f.s[f.i+1] == '\'':
+			b.WriteByte('\'')
+			f.i += 2
+		case c == quote:
+			f.i++
+			return b.String(), nil
+		case c == '\\' && quote == '"':
+			if err := f.parseEscape(&b); err != nil {
+				return nil, err
+			}
+		default:
+			b.WriteByte(c)
+			f.i++
+		}
+	}
+	return nil, fmt.Errorf("quote %c is not closed", quote)
+}
+
+func (f *yamlFlow) parseEscape(b *strings.Builder) error {
+	if f.i+1 >= len(f.s) {
+		return fmt.Errorf("escape is not closed")
+	}
+	c := f.s[f.i+1]
+	f.i += 2
+	switch c {
+	case 'n':
+		b.WriteByte('\n')
+	case 't':
+		b.WriteByte('\t')
+	case 'r':
+		b.WriteByte('\r')
+	case '0':
+		b.WriteByte(0)
+	case '"', '\\', '/', ' ':
+		b.WriteByte(c)
+	case 'x', 'u', 'U':
+		n := map[byte]int{'x': 2, 'u': 4, 'U': 8}[c]
+		if f.i+n > len(f.s) {
+			return fmt.Errorf("escape \\%c is too short", c)
+		}
+		v, err := strconv.ParseUint(f.s[f.i:f.i+n], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(v)) {
+			return fmt.Errorf("invalidate escape \\%c%s", c, f.s[f.i:f.i+n])
+		}
+		b.WriteRune(rune(v))
+		f.i += n
+	default:
+		return fmt.Errorf("escape \\%c is not supported", c)
+	}
+	return nil
+}
+
+func (f *yamlFlow) parsePlain(inFlow bool) (interface{}, error) {
+	start := f.i
+	for f.i < len(f.s) {
+		c := f.s[f.i]
+		if inFlow && (c == ',' || c == ']' || c == '}') {
+			break
+		}
+		if c == ':' && (f.i+1 == len(f.s) || f.s[f.i+1] == ' ' || (inFlow && strings.IndexByte(",]}", f.s[f.i+1]) >= 0)) {
+			break
+		}
+		f.i++
+	}
+	return resolveYAMLScalar(strings.TrimSpace(f.s[start:f.i])), nil
+}
+
+// resolveYAMLScalar types the plain scalar like YAML 1.2 core schema.
+func resolveYAMLScalar(s string) interface{} {
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+	if yamlIntPattern.MatchString(s) {
+		if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return v
+		}
+	}
+	if yamlFloatPattern.MatchString(s) {
+		if v, err := strconv.ParseFloat(s, 64); err == nil {
+			return v
+		}
+	}
+	return s
+}
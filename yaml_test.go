@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestYAMLToJSON(t *testing.T) {
+	tests := []struct {
+		yaml string
+		want string
+	}{
+		{"a: 1\nb: x\n", `{"a":1,"b":"x"}`},
+		{"a: true\nb: ~\nc: 1.5\nd: '007'\ne: \"a\\tb\"\n", `{"a":true,"b":null,"c":1.5,"d":"007","e":"a\tb"}`},
+		{"# comment\n---\na: x # comment\nb: 'it''s # not a comment'\n", `{"a":"x","b":"it's # not a comment"}`},
+		{"url: http://example.com/a#b\n", `{"url":"http://example.com/a#b"}`},
+		{"a:\n  b:\n    c: x\n", `{"a":{"b":{"c":"x"}}}`},
+		{"a:\n- x\n- y\nb:\n  - 1\n", `{"a":["x","y"],"b":[1]}`},
+		{"- a: 1\n  b: 2\n- c: 3\n", `[{"a":1,"b":2},{"c":3}]`},
+		{"- - 1\n  - 2\n- 3\n", `[[1,2],3]`},
+		{"a: [x, 'y, z', {k: v}]\nb: {c: [1, 2], d: \"e\"}\n", `{"a":["x","y, z",{"k":"v"}],"b":{"c":[1,2],"d":"e"}}`},
+		{"a: [x,\n  y]\n", `{"a":["x","y"]}`},
+		{"a: |\n  line1\n  line2\n\nb: x\n", `{"a":"line1\nline2\n","b":"x"}`},
+		{"a: |-\n  line1\n    line2\n", `{"a":"line1\n  line2"}`},
+		{"a: >\n  folded\n  text\n\n  next\n", `{"a":"folded text\nnext\n"}`},
+		{"- |\n  x\n- y\n", `["x\n","y"]`},
+		{"\"a b\": 1\n", `{"a b":1}`},
+	}
+
+	for _, tt := range tests {
+		got, err := yamlToJSON([]byte(tt.yaml))
+		if err != nil {
+			t.Errorf("%q failed. %v", tt.yaml, err)
+			continue
+		}
+		var g, w interface{}
+		json.Unmarshal(got, &g)
+		json.Unmarshal([]byte(tt.want), &w)
+		if !reflect.DeepEqual(g, w) {
+			t.Errorf("%q: got %s, want %s", tt.yaml, got, tt.want)
+		}
+	}
+}
+
+func TestYAMLToJSONError(t *testing.T) {
+	for _, s := range []string{
+		"a: 1\na: 2\n",
+		"a: 1\n  b: 2\n",
+		"a: [1, 2\n",
+		"a: 'x\n",
+		"a: *ref\n",
+		"a:\n\tb: 1\n",
+		"- a\nb: 1\n",
+	} {
+		if _, err := yamlToJSON([]byte(s)); err == nil {
+			t.Errorf("%q is accepted", s)
+		}
+	}
+}
+
+func TestLoadWorkflowYAML(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "wf.json")
+	yamlPath := filepath.Join(dir, "wf.yaml")
+	ioutil.WriteFile(jsonPath, []byte(`{
+  "name": "invoice",
+  "steps": [
+    {"id": "fetch", "bot_id": "A", "input": {"customer": "{{.params.customer}}"}, "retries": 2, "retry_interval": 10},
+    {"id": "send", "bot_id": "B", "needs": ["fetch"], "input": {"file": "{{.steps.fetch.output.file}}"}},
+    {"id": "notify", "bot_id": "C", "needs": ["fetch"], "when": "{{eq .steps.fetch.status \"exit\"}}", "continue_on_error": true}
+  ]
+}`), 0600)
+	ioutil.WriteFile(yamlPath, []byte(`name: invoice
+steps:
+  - id: fetch
+    bot_id: A
+    input:
+      customer: "{{.params.customer}}"
+    retries: 2
+    retry_interval: 10
+  - id: send
+    bot_id: B
+    needs: [fetch]
+    input: {file: "{{.steps.fetch.output.file}}"}
+  - id: notify
+    bot_id: C
+    needs:
+      - fetch
+    when: '{{eq .steps.fetch.status "exit"}}'
+    continue_on_error: true
+`), 0600)
+
+	want, err := loadWorkflow(jsonPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadWorkflow(yamlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		g, _ := json.Marshal(got)
+		w, _ := json.Marshal(want)
+		t.Errorf("got %s, want %s", g, w)
+	}
+}